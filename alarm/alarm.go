@@ -0,0 +1,93 @@
+package alarm
+
+import (
+	"fmt"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/storage"
+	"strings"
+	"time"
+)
+
+type State string
+
+const (
+	StateRaised       State = "Raised"
+	StateAcknowledged State = "Acknowledged"
+	StateCleared      State = "Cleared"
+)
+
+type Alarm struct {
+	Rule      *config.AlarmRuleConfig
+	Device    *storage.Device
+	ValueName string
+	State     State
+	Value     float64
+	Unit      string
+	Message   string
+	Raised    time.Time
+	Updated   time.Time
+}
+
+// this is what is sent to the api, websocket and mqtt clients
+type AlarmMessage struct {
+	RuleName   string
+	DeviceName string
+	ValueName  string
+	State      State
+	Value      float64
+	Unit       string
+	Message    string
+	Raised     time.Time
+	Updated    time.Time
+}
+
+func (alarm Alarm) ConvertToMessage() AlarmMessage {
+	return AlarmMessage{
+		RuleName:   alarm.Rule.Name,
+		DeviceName: alarm.Device.Name,
+		ValueName:  alarm.ValueName,
+		State:      alarm.State,
+		Value:      alarm.Value,
+		Unit:       alarm.Unit,
+		Message:    alarm.Message,
+		Raised:     alarm.Raised,
+		Updated:    alarm.Updated,
+	}
+}
+
+func ConvertToMessages(alarms []Alarm) (messages []AlarmMessage) {
+	messages = make([]AlarmMessage, len(alarms))
+	for i, alarm := range alarms {
+		messages[i] = alarm.ConvertToMessage()
+	}
+	return
+}
+
+func formatMessage(rule *config.AlarmRuleConfig, device *storage.Device, value float64, unit string) string {
+	message := rule.Message
+	if len(message) < 1 {
+		message = defaultMessage(rule)
+	}
+
+	message = strings.Replace(message, "%DeviceName%", device.Name, -1)
+	message = strings.Replace(message, "%ValueName%", rule.Value, -1)
+	message = strings.Replace(message, "%Value%", fmt.Sprintf("%v", value), -1)
+	message = strings.Replace(message, "%Unit%", unit, -1)
+	message = strings.Replace(message, "%Threshold%", fmt.Sprintf("%v", rule.Threshold), -1)
+	message = strings.Replace(message, "%Duration%", rule.Duration.String(), -1)
+	return message
+}
+
+func defaultMessage(rule *config.AlarmRuleConfig) string {
+	switch rule.Type {
+	case "Above":
+		return "%DeviceName%: %ValueName% is above %Threshold%%Unit% (%Value%%Unit%)"
+	case "Below":
+		return "%DeviceName%: %ValueName% is below %Threshold%%Unit% (%Value%%Unit%)"
+	case "RateOfChange":
+		return "%DeviceName%: %ValueName% changes faster than %Threshold%%Unit%/min (%Value%%Unit%)"
+	case "Missing":
+		return "%DeviceName%: %ValueName% has not been updated for %Duration%"
+	}
+	return "%DeviceName%: %ValueName% alarm"
+}
@@ -0,0 +1,326 @@
+package alarm
+
+import (
+	"errors"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+type Engine struct {
+	// this represents the state of the engine and must only be access by the main go routine
+	rules         []*config.AlarmRuleConfig
+	ruleStates    map[ruleDeviceKey]*ruleState
+	devicesSeen   map[*storage.Device]time.Time
+	subscriptions []chan Alarm
+
+	// communication channels to/from the main go routine
+	inputChannel              chan dataflow.Value
+	subscriptionChannel       chan chan Alarm
+	unsubscriptionChannel     chan (<-chan Alarm)
+	readActiveRequestChannel  chan chan []Alarm
+	acknowledgeRequestChannel chan *acknowledgeRequest
+}
+
+// alarm events buffered per subscriber before further events are dropped
+const subscriptionBufferSize = 64
+
+type ruleDeviceKey struct {
+	rule   *config.AlarmRuleConfig
+	device *storage.Device
+}
+
+type ruleState struct {
+	lastValue  float64
+	lastUnit   string
+	lastUpdate time.Time
+	alarm      *Alarm
+}
+
+type acknowledgeRequest struct {
+	ruleName   string
+	deviceName string
+	response   chan error
+}
+
+func EngineCreate(rules []*config.AlarmRuleConfig) (engine *Engine) {
+	engine = &Engine{
+		rules:                     rules,
+		ruleStates:                make(map[ruleDeviceKey]*ruleState),
+		devicesSeen:               make(map[*storage.Device]time.Time),
+		inputChannel:              make(chan dataflow.Value, 32), // input channel is buffered
+		subscriptionChannel:       make(chan chan Alarm),
+		unsubscriptionChannel:     make(chan (<-chan Alarm)),
+		readActiveRequestChannel:  make(chan chan []Alarm),
+		acknowledgeRequestChannel: make(chan *acknowledgeRequest),
+	}
+
+	// start main go routine
+	go engine.mainEngineRoutine()
+
+	return
+}
+
+func (engine *Engine) mainEngineRoutine() {
+	// check for missing values once a second
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case newValue := <-engine.inputChannel:
+			engine.handleNewValue(newValue, time.Now())
+		case now := <-ticker.C:
+			engine.handleTick(now)
+		case newSubscription := <-engine.subscriptionChannel:
+			engine.subscriptions = append(engine.subscriptions, newSubscription)
+		case output := <-engine.unsubscriptionChannel:
+			for i, subscription := range engine.subscriptions {
+				if subscription == output {
+					close(subscription)
+					engine.subscriptions = append(engine.subscriptions[:i], engine.subscriptions[i+1:]...)
+					break
+				}
+			}
+		case response := <-engine.readActiveRequestChannel:
+			response <- engine.getActive()
+		case request := <-engine.acknowledgeRequestChannel:
+			request.response <- engine.acknowledge(request.ruleName, request.deviceName)
+		}
+	}
+}
+
+func (engine *Engine) handleNewValue(value dataflow.Value, now time.Time) {
+	// a staleness event does not contain a new measurement
+	if value.Stale {
+		return
+	}
+
+	if _, ok := engine.devicesSeen[value.Device]; !ok {
+		engine.devicesSeen[value.Device] = now
+	}
+
+	for _, rule := range engine.rules {
+		if !ruleMatches(rule, value.Device, value.Name) {
+			continue
+		}
+
+		key := ruleDeviceKey{rule: rule, device: value.Device}
+		state, ok := engine.ruleStates[key]
+		if !ok {
+			state = &ruleState{}
+			engine.ruleStates[key] = state
+		}
+
+		switch rule.Type {
+		case "Above":
+			engine.evaluateLevel(key, state, value.Value, value.Unit,
+				value.Value > rule.Threshold,
+				value.Value < rule.Threshold-rule.Hysteresis,
+				now,
+			)
+		case "Below":
+			engine.evaluateLevel(key, state, value.Value, value.Unit,
+				value.Value < rule.Threshold,
+				value.Value > rule.Threshold+rule.Hysteresis,
+				now,
+			)
+		case "RateOfChange":
+			if !state.lastUpdate.IsZero() {
+				if elapsed := now.Sub(state.lastUpdate).Minutes(); elapsed > 0 {
+					rate := math.Abs(value.Value-state.lastValue) / elapsed
+					engine.evaluateLevel(key, state, value.Value, value.Unit,
+						rate > rule.Threshold,
+						rate < rule.Threshold-rule.Hysteresis,
+						now,
+					)
+				}
+			}
+		case "Missing":
+			// any update clears a missing alarm
+			engine.evaluateLevel(key, state, value.Value, value.Unit, false, true, now)
+		}
+
+		state.lastValue = value.Value
+		state.lastUnit = value.Unit
+		state.lastUpdate = now
+	}
+}
+
+func (engine *Engine) handleTick(now time.Time) {
+	for _, rule := range engine.rules {
+		if rule.Type != "Missing" {
+			continue
+		}
+
+		for device, firstSeen := range engine.devicesSeen {
+			if !ruleMatches(rule, device, rule.Value) {
+				continue
+			}
+
+			key := ruleDeviceKey{rule: rule, device: device}
+			state, ok := engine.ruleStates[key]
+			if !ok {
+				state = &ruleState{}
+				engine.ruleStates[key] = state
+			}
+
+			lastUpdate := state.lastUpdate
+			if lastUpdate.IsZero() {
+				lastUpdate = firstSeen
+			}
+
+			if state.alarm == nil && now.Sub(lastUpdate) > rule.Duration {
+				engine.raise(key, state, state.lastValue, state.lastUnit, now)
+			}
+		}
+	}
+}
+
+// evaluateLevel implements the hysteresis: once raised, an alarm stays active until the clear condition is met
+func (engine *Engine) evaluateLevel(
+	key ruleDeviceKey,
+	state *ruleState,
+	value float64,
+	unit string,
+	raiseCondition bool,
+	clearCondition bool,
+	now time.Time,
+) {
+	if state.alarm == nil {
+		if raiseCondition {
+			engine.raise(key, state, value, unit, now)
+		}
+		return
+	}
+
+	if clearCondition {
+		engine.clear(state, value, unit, now)
+		return
+	}
+
+	// update the current value of the active alarm without notifying subscribers
+	state.alarm.Value = value
+	state.alarm.Unit = unit
+}
+
+func (engine *Engine) raise(key ruleDeviceKey, state *ruleState, value float64, unit string, now time.Time) {
+	state.alarm = &Alarm{
+		Rule:      key.rule,
+		Device:    key.device,
+		ValueName: key.rule.Value,
+		State:     StateRaised,
+		Value:     value,
+		Unit:      unit,
+		Message:   formatMessage(key.rule, key.device, value, unit),
+		Raised:    now,
+		Updated:   now,
+	}
+	engine.forward(*state.alarm)
+}
+
+func (engine *Engine) clear(state *ruleState, value float64, unit string, now time.Time) {
+	alarm := *state.alarm
+	alarm.State = StateCleared
+	alarm.Value = value
+	alarm.Unit = unit
+	alarm.Updated = now
+	state.alarm = nil
+	engine.forward(alarm)
+}
+
+func (engine *Engine) acknowledge(ruleName, deviceName string) error {
+	for key, state := range engine.ruleStates {
+		if key.rule.Name != ruleName || key.device.Name != deviceName {
+			continue
+		}
+
+		if state.alarm == nil {
+			return errors.New("alarm is not active")
+		}
+
+		if state.alarm.State != StateAcknowledged {
+			state.alarm.State = StateAcknowledged
+			state.alarm.Updated = time.Now()
+			engine.forward(*state.alarm)
+		}
+		return nil
+	}
+
+	return errors.New("alarm not found")
+}
+
+func (engine *Engine) getActive() (alarms []Alarm) {
+	alarms = make([]Alarm, 0)
+	for _, state := range engine.ruleStates {
+		if state.alarm != nil {
+			alarms = append(alarms, *state.alarm)
+		}
+	}
+
+	// oldest alarm first
+	sort.Slice(alarms, func(i, j int) bool {
+		return alarms[i].Raised.Before(alarms[j].Raised)
+	})
+	return
+}
+
+// forward never blocks the engine: events are dropped for subscribers whose buffer is full
+func (engine *Engine) forward(alarm Alarm) {
+	for _, subscription := range engine.subscriptions {
+		select {
+		case subscription <- alarm:
+		default:
+			log.Printf("alarm: subscriber too slow, drop %v event of rule=%v device=%v",
+				alarm.State, alarm.Rule.Name, alarm.Device.Name)
+		}
+	}
+}
+
+func ruleMatches(rule *config.AlarmRuleConfig, device *storage.Device, valueName string) bool {
+	if len(rule.Device) > 0 && rule.Device != device.Name {
+		return false
+	}
+	return rule.Value == valueName
+}
+
+// this is a simple fan-in routine which copies all inputs to the input channel of the engine
+func (engine *Engine) Fill(input <-chan dataflow.Value) {
+	go func() {
+		for value := range input {
+			engine.inputChannel <- value
+		}
+	}()
+}
+
+// Subscribe returns a channel receiving every state change (raised, acknowledged, cleared) of every alarm
+func (engine *Engine) Subscribe() <-chan Alarm {
+	output := make(chan Alarm, subscriptionBufferSize)
+	engine.subscriptionChannel <- output
+	return output
+}
+
+// Unsubscribe removes the subscription and closes its channel
+func (engine *Engine) Unsubscribe(output <-chan Alarm) {
+	engine.unsubscriptionChannel <- output
+}
+
+func (engine *Engine) GetActive() []Alarm {
+	response := make(chan []Alarm)
+	engine.readActiveRequestChannel <- response
+	return <-response
+}
+
+func (engine *Engine) Acknowledge(ruleName, deviceName string) error {
+	response := make(chan error)
+	engine.acknowledgeRequestChannel <- &acknowledgeRequest{
+		ruleName:   ruleName,
+		deviceName: deviceName,
+		response:   response,
+	}
+	return <-response
+}
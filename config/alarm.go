@@ -0,0 +1,94 @@
+package config
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+type AlarmRuleConfigRead struct {
+	Device     string
+	Value      string
+	Type       string
+	Threshold  float64
+	Hysteresis float64
+	Duration   string
+	Message    string
+}
+
+type AlarmRuleConfig struct {
+	Name string
+
+	// empty string: rule applies to every device
+	Device string
+	Value  string
+
+	// Above:        raise when Value > Threshold, clear when Value < Threshold - Hysteresis
+	// Below:        raise when Value < Threshold, clear when Value > Threshold + Hysteresis
+	// RateOfChange: raise when the absolute change per minute exceeds Threshold
+	// Missing:      raise when the value has not been updated for Duration
+	Type       string
+	Threshold  float64
+	Hysteresis float64
+	Duration   time.Duration
+	Message    string
+}
+
+const alarmRulePrefix = "AlarmRule."
+
+func GetAlarmRuleConfig(sectionName string) (ruleConfig *AlarmRuleConfig) {
+	ruleConfigRead := &AlarmRuleConfigRead{
+		Device:     "",
+		Value:      "",
+		Type:       "Above",
+		Threshold:  0,
+		Hysteresis: 0,
+		Duration:   "5m",
+		Message:    "",
+	}
+
+	err := config.Section(sectionName).MapTo(ruleConfigRead)
+	if err != nil {
+		log.Fatalf("config: cannot read alarm rule configuration: %v", err)
+	}
+
+	switch ruleConfigRead.Type {
+	case "Above", "Below", "RateOfChange", "Missing":
+	default:
+		log.Fatalf("config: unknown alarm rule Type=%v in section %v", ruleConfigRead.Type, sectionName)
+	}
+
+	if len(ruleConfigRead.Value) < 1 {
+		log.Fatalf("config: alarm rule Value missing in section %v", sectionName)
+	}
+
+	duration, err := time.ParseDuration(ruleConfigRead.Duration)
+	if err != nil {
+		log.Fatalf("config: cannot parse alarm rule Duration in section %v: %v", sectionName, err)
+	}
+
+	ruleConfig = &AlarmRuleConfig{
+		Name:       sectionName[len(alarmRulePrefix):],
+		Device:     ruleConfigRead.Device,
+		Value:      ruleConfigRead.Value,
+		Type:       ruleConfigRead.Type,
+		Threshold:  ruleConfigRead.Threshold,
+		Hysteresis: ruleConfigRead.Hysteresis,
+		Duration:   duration,
+		Message:    ruleConfigRead.Message,
+	}
+
+	return
+}
+
+func GetAlarmRuleConfigs() (ruleConfigs []*AlarmRuleConfig) {
+	sections := config.SectionStrings()
+	for _, sectionName := range sections {
+		if !strings.HasPrefix(sectionName, alarmRulePrefix) {
+			continue
+		}
+		ruleConfigs = append(ruleConfigs, GetAlarmRuleConfig(sectionName))
+	}
+
+	return
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type AuthConfigRead struct {
+	AnonymousRole string
+	JwtSecret     string
+	JwtRoleClaim  string
+}
+
+type AuthConfig struct {
+	// role granted to requests without credentials; empty: credentials are required
+	AnonymousRole string
+
+	// empty: JWTs are not accepted; otherwise HS256 tokens signed with this secret are accepted as bearer tokens
+	JwtSecret string
+	// name of the claim holding the role of a JWT
+	JwtRoleClaim string
+
+	// read from the [AuthToken.<Name>] sections
+	Tokens []AuthTokenConfig
+	// read from the [AuthUser.<Name>] sections
+	Users []AuthUserConfig
+	// read from the [AuthCert.<CommonName>] sections
+	Certs []AuthCertConfig
+}
+
+type AuthTokenConfig struct {
+	Name  string
+	Token string
+	Role  string
+}
+
+type AuthUserConfig struct {
+	Name string
+	// bcrypt hash, e.g. generated by htpasswd -nB <user>
+	PasswordHash string
+	Role         string
+}
+
+// clients presenting a certificate verified against the ClientCaFile of the HttpServer get the role of its common name
+type AuthCertConfig struct {
+	CommonName string
+	Role       string
+}
+
+// ordered from least to most privileged; every role includes the permissions of the roles before it
+var AuthRoles = []string{"viewer", "operator", "admin"}
+
+// returned when there is no [Auth] section; any other error must not silently disable the authentication
+var ErrNoAuthConfig = errors.New("no auth configuration found")
+
+const authTokenPrefix = "AuthToken."
+const authUserPrefix = "AuthUser."
+const authCertPrefix = "AuthCert."
+
+func GetAuthConfig() (authConfig *AuthConfig, err error) {
+	authConfigRead := &AuthConfigRead{
+		AnonymousRole: "",
+		JwtSecret:     "",
+		JwtRoleClaim:  "role",
+	}
+
+	// check if auth sections exists
+	_, err = config.GetSection("Auth")
+	if err != nil {
+		return nil, ErrNoAuthConfig
+	}
+
+	err = config.Section("Auth").MapTo(authConfigRead)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read auth configuration: %v", err)
+	}
+
+	if len(authConfigRead.AnonymousRole) > 0 && !isAuthRole(authConfigRead.AnonymousRole) {
+		return nil, fmt.Errorf("Auth: unknown AnonymousRole=%v", authConfigRead.AnonymousRole)
+	}
+
+	if len(authConfigRead.JwtSecret) > 0 && len(authConfigRead.JwtRoleClaim) < 1 {
+		return nil, errors.New("Auth: JwtRoleClaim missing")
+	}
+
+	authConfig = &AuthConfig{
+		AnonymousRole: authConfigRead.AnonymousRole,
+		JwtSecret:     authConfigRead.JwtSecret,
+		JwtRoleClaim:  authConfigRead.JwtRoleClaim,
+		Tokens:        make([]AuthTokenConfig, 0),
+		Users:         make([]AuthUserConfig, 0),
+		Certs:         make([]AuthCertConfig, 0),
+	}
+
+	for _, sectionName := range config.SectionStrings() {
+		switch {
+		case strings.HasPrefix(sectionName, authTokenPrefix):
+			tokenConfig := AuthTokenConfig{
+				Name: sectionName[len(authTokenPrefix):],
+				Role: "viewer",
+			}
+			if err := config.Section(sectionName).MapTo(&tokenConfig); err != nil {
+				return nil, fmt.Errorf("cannot read auth token configuration: %v", err)
+			}
+			if len(tokenConfig.Token) < 16 {
+				return nil, fmt.Errorf("%v: Token must be at least 16 characters long", sectionName)
+			}
+			if !isAuthRole(tokenConfig.Role) {
+				return nil, fmt.Errorf("%v: unknown Role=%v", sectionName, tokenConfig.Role)
+			}
+			authConfig.Tokens = append(authConfig.Tokens, tokenConfig)
+		case strings.HasPrefix(sectionName, authUserPrefix):
+			userConfig := AuthUserConfig{
+				Name: sectionName[len(authUserPrefix):],
+				Role: "viewer",
+			}
+			if err := config.Section(sectionName).MapTo(&userConfig); err != nil {
+				return nil, fmt.Errorf("cannot read auth user configuration: %v", err)
+			}
+			if !strings.HasPrefix(userConfig.PasswordHash, "$2") {
+				return nil, fmt.Errorf("%v: PasswordHash must be a bcrypt hash", sectionName)
+			}
+			if !isAuthRole(userConfig.Role) {
+				return nil, fmt.Errorf("%v: unknown Role=%v", sectionName, userConfig.Role)
+			}
+			authConfig.Users = append(authConfig.Users, userConfig)
+		case strings.HasPrefix(sectionName, authCertPrefix):
+			certConfig := AuthCertConfig{
+				CommonName: sectionName[len(authCertPrefix):],
+				Role:       "viewer",
+			}
+			if err := config.Section(sectionName).MapTo(&certConfig); err != nil {
+				return nil, fmt.Errorf("cannot read auth cert configuration: %v", err)
+			}
+			if !isAuthRole(certConfig.Role) {
+				return nil, fmt.Errorf("%v: unknown Role=%v", sectionName, certConfig.Role)
+			}
+			authConfig.Certs = append(authConfig.Certs, certConfig)
+		}
+	}
+
+	return
+}
+
+func isAuthRole(role string) bool {
+	for _, r := range AuthRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
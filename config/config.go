@@ -6,6 +6,9 @@ import (
 	"path/filepath"
 	"io/ioutil"
 	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var config *ini.File
@@ -46,3 +49,35 @@ func readJsonConfig(frontendConfigPath string) (frontendConfig interface{}) {
 
 	return
 }
+
+// splitList converts a comma separated list into a slice; empty entries are ignored
+func splitList(list string) (items []string) {
+	items = make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// resolvePath interprets relative paths as relative to the directory of the config file
+func resolvePath(path string) string {
+	if len(path) < 1 || filepath.IsAbs(path) {
+		return path
+	}
+	return configDir + path
+}
+
+// parseLongDuration extends time.ParseDuration by a days suffix, e.g. 90d
+func parseLongDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
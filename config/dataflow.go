@@ -0,0 +1,54 @@
+package config
+
+import (
+	"log"
+	"time"
+)
+
+type DataflowConfigRead struct {
+	StaleTimeout     string
+	SnapshotFile     string
+	SnapshotInterval string
+}
+
+type DataflowConfig struct {
+	// values not updated by their source within this duration are marked as stale; 0 disables it
+	StaleTimeout time.Duration
+
+	// the state is saved to this file every SnapshotInterval and on shutdown and restored on startup;
+	// relative paths are relative to the config file, empty disables it
+	SnapshotFile     string
+	SnapshotInterval time.Duration
+}
+
+func GetDataflowConfig() (dataflowConfig *DataflowConfig) {
+	dataflowConfigRead := &DataflowConfigRead{
+		StaleTimeout:     "60s",
+		SnapshotFile:     "",
+		SnapshotInterval: "1m",
+	}
+
+	// the section is optional; the defaults are used if it is missing
+	err := config.Section("Dataflow").MapTo(dataflowConfigRead)
+	if err != nil {
+		log.Fatalf("config: cannot read dataflow configuration: %v", err)
+	}
+
+	staleTimeout, err := time.ParseDuration(dataflowConfigRead.StaleTimeout)
+	if err != nil {
+		log.Fatalf("config: cannot parse Dataflow.StaleTimeout: %v", err)
+	}
+
+	snapshotInterval, err := time.ParseDuration(dataflowConfigRead.SnapshotInterval)
+	if err != nil || snapshotInterval <= 0 {
+		log.Fatalf("config: cannot parse Dataflow.SnapshotInterval: %v", dataflowConfigRead.SnapshotInterval)
+	}
+
+	dataflowConfig = &DataflowConfig{
+		StaleTimeout:     staleTimeout,
+		SnapshotFile:     resolvePath(dataflowConfigRead.SnapshotFile),
+		SnapshotInterval: snapshotInterval,
+	}
+
+	return
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+type DeadbandRuleConfigRead struct {
+	Absolute    float64
+	Percent     float64
+	MinInterval string
+	MaxInterval string
+}
+
+type DeadbandRuleConfig struct {
+	// a new value is only forwarded if it differs by at least Absolute or Percent from the last forwarded one
+	// both 0: every change is forwarded
+	Absolute float64
+	Percent  float64
+	// forward at most one value within MinInterval
+	MinInterval time.Duration
+	// repeat the last value after MaxInterval even if unchanged; 0 disables it
+	MaxInterval time.Duration
+}
+
+type DeadbandConfig struct {
+	// read from the [Deadband] section, used for every value without its own section
+	Default DeadbandRuleConfig
+	// read from the [Deadband.<ValueName>] sections
+	Rules map[string]DeadbandRuleConfig
+}
+
+const deadbandPrefix = "Deadband."
+
+func GetDeadbandConfig() (deadbandConfig *DeadbandConfig) {
+	deadbandConfig = &DeadbandConfig{
+		Default: getDeadbandRuleConfig("Deadband"),
+		Rules:   make(map[string]DeadbandRuleConfig),
+	}
+
+	sections := config.SectionStrings()
+	for _, sectionName := range sections {
+		if !strings.HasPrefix(sectionName, deadbandPrefix) {
+			continue
+		}
+		deadbandConfig.Rules[sectionName[len(deadbandPrefix):]] = getDeadbandRuleConfig(sectionName)
+	}
+
+	return
+}
+
+func getDeadbandRuleConfig(sectionName string) DeadbandRuleConfig {
+	ruleConfigRead := &DeadbandRuleConfigRead{
+		Absolute:    0,
+		Percent:     0,
+		MinInterval: "0s",
+		MaxInterval: "0s",
+	}
+
+	err := config.Section(sectionName).MapTo(ruleConfigRead)
+	if err != nil {
+		log.Fatalf("config: cannot read deadband configuration: %v", err)
+	}
+
+	minInterval, err := time.ParseDuration(ruleConfigRead.MinInterval)
+	if err != nil {
+		log.Fatalf("config: cannot parse MinInterval in section %v: %v", sectionName, err)
+	}
+
+	maxInterval, err := time.ParseDuration(ruleConfigRead.MaxInterval)
+	if err != nil {
+		log.Fatalf("config: cannot parse MaxInterval in section %v: %v", sectionName, err)
+	}
+
+	return DeadbandRuleConfig{
+		Absolute:    ruleConfigRead.Absolute,
+		Percent:     ruleConfigRead.Percent,
+		MinInterval: minInterval,
+		MaxInterval: maxInterval,
+	}
+}
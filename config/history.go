@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type HistoryConfigRead struct {
+	Backend            string
+	Capacity           int
+	File               string
+	CompactionInterval string
+}
+
+type HistoryConfig struct {
+	// Memory: bounded ring buffer per device and value, lost on restart
+	// Bolt:   embedded database file with retention and downsampling tiers
+	// Mongo:  mongodb database configured in the [Mongo] section, with retention and downsampling tiers
+	Backend string
+
+	// Memory: number of samples kept per device and value
+	Capacity int
+
+	// Bolt: path of the database file; relative paths are relative to the config file
+	File string
+
+	// Bolt and Mongo: read from the [HistoryTier.<Name>] sections, ordered by resolution, raw tier first
+	Tiers []HistoryTierConfig
+	// Bolt and Mongo: samples older than the retention of their tier are deleted every CompactionInterval
+	CompactionInterval time.Duration
+}
+
+type HistoryTierConfigRead struct {
+	Resolution string
+	Retention  string
+}
+
+type HistoryTierConfig struct {
+	Name string
+	// 0: raw samples, otherwise the average over this duration is stored
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+const historyTierPrefix = "HistoryTier."
+
+// used when no [HistoryTier.<Name>] sections are configured
+var defaultHistoryTiers = []HistoryTierConfig{
+	{Name: "raw", Resolution: 0, Retention: 48 * time.Hour},
+	{Name: "1m", Resolution: time.Minute, Retention: 90 * 24 * time.Hour},
+	{Name: "1h", Resolution: time.Hour, Retention: 5 * 365 * 24 * time.Hour},
+}
+
+func GetHistoryConfig() (historyConfig *HistoryConfig, err error) {
+	historyConfigRead := &HistoryConfigRead{
+		Backend:            "Memory",
+		Capacity:           3600,
+		File:               "history.db",
+		CompactionInterval: "10m",
+	}
+
+	// check if history sections exists
+	_, err = config.GetSection("History")
+	if err != nil {
+		return nil, errors.New("no history configuration found")
+	}
+
+	err = config.Section("History").MapTo(historyConfigRead)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read history configuration: %v", err)
+	}
+
+	switch historyConfigRead.Backend {
+	case "Memory":
+		if historyConfigRead.Capacity < 1 {
+			return nil, errors.New("History: Capacity must be positive")
+		}
+	case "Bolt":
+		if len(historyConfigRead.File) < 1 {
+			return nil, errors.New("History: File missing")
+		}
+	case "Mongo":
+	default:
+		return nil, fmt.Errorf("History: unknown Backend=%v", historyConfigRead.Backend)
+	}
+
+	compactionInterval, err := time.ParseDuration(historyConfigRead.CompactionInterval)
+	if err != nil || compactionInterval <= 0 {
+		return nil, fmt.Errorf("History: invalid CompactionInterval: %v", historyConfigRead.CompactionInterval)
+	}
+
+	tiers, err := getHistoryTierConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	historyConfig = &HistoryConfig{
+		Backend:            historyConfigRead.Backend,
+		Capacity:           historyConfigRead.Capacity,
+		File:               resolvePath(historyConfigRead.File),
+		Tiers:              tiers,
+		CompactionInterval: compactionInterval,
+	}
+
+	return
+}
+
+func getHistoryTierConfigs() (tiers []HistoryTierConfig, err error) {
+	tiers = make([]HistoryTierConfig, 0)
+
+	for _, sectionName := range config.SectionStrings() {
+		if !strings.HasPrefix(sectionName, historyTierPrefix) {
+			continue
+		}
+
+		tierConfigRead := &HistoryTierConfigRead{
+			Resolution: "0s",
+			Retention:  "",
+		}
+
+		if err := config.Section(sectionName).MapTo(tierConfigRead); err != nil {
+			return nil, fmt.Errorf("cannot read history tier configuration: %v", err)
+		}
+
+		resolution, err := parseLongDuration(tierConfigRead.Resolution)
+		if err != nil || resolution < 0 {
+			return nil, fmt.Errorf("%v: invalid Resolution: %v", sectionName, tierConfigRead.Resolution)
+		}
+
+		retention, err := parseLongDuration(tierConfigRead.Retention)
+		if err != nil || retention <= 0 {
+			return nil, fmt.Errorf("%v: invalid Retention: %v", sectionName, tierConfigRead.Retention)
+		}
+
+		if resolution > 0 && retention < resolution {
+			return nil, fmt.Errorf("%v: Retention must be at least Resolution", sectionName)
+		}
+
+		tiers = append(tiers, HistoryTierConfig{
+			Name:       sectionName[len(historyTierPrefix):],
+			Resolution: resolution,
+			Retention:  retention,
+		})
+	}
+
+	if len(tiers) < 1 {
+		return defaultHistoryTiers, nil
+	}
+
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].Resolution < tiers[j].Resolution
+	})
+
+	// aggregated tiers are computed from the incoming values; a raw tier is needed for queries with a fine step
+	if tiers[0].Resolution != 0 {
+		return nil, errors.New("History: a tier with Resolution=0 (raw samples) is required")
+	}
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Resolution == tiers[i-1].Resolution {
+			return nil, fmt.Errorf("History: tiers %v and %v have the same Resolution", tiers[i-1].Name, tiers[i].Name)
+		}
+	}
+
+	return tiers, nil
+}
@@ -14,12 +14,40 @@ type HttpServerConfigRead struct {
 	// -           : log to stdoud (default)
 	// else        : used as file path for a log file
 	LogFile string
+
+	// apply the deadband rules to the websocket outputs
+	WsDeadband bool
+
+	CertFile           string
+	KeyFile            string
+	RedirectPort       int
+	ClientCaFile       string
+	ClientCertRequired bool
+	Http2              bool
 }
 type HttpServerConfig struct {
 	Bind           string
 	Port           int
 	FrontendConfig interface{}
 	LogFile        string
+	WsDeadband     bool
+
+	// empty: plain http; otherwise https is served on Port using this certificate,
+	// which is reloaded on SIGHUP and when the files change
+	CertFile string
+	KeyFile  string
+	// 0: disabled; otherwise plain http requests to this port are redirected to https
+	RedirectPort int
+	// empty: no client certificates; otherwise client certificates signed by this CA are verified
+	ClientCaFile string
+	// connections without a valid client certificate are rejected
+	ClientCertRequired bool
+	// negotiate HTTP/2 when serving https
+	Http2 bool
+}
+
+func (httpServerConfig *HttpServerConfig) Tls() bool {
+	return len(httpServerConfig.CertFile) > 0
 }
 
 func GetHttpServerConfig() (httpServerConfig *HttpServerConfig, err error) {
@@ -28,6 +56,13 @@ func GetHttpServerConfig() (httpServerConfig *HttpServerConfig, err error) {
 		Port:               0,
 		FrontendConfigPath: "",
 		LogFile:            "",
+		WsDeadband:         false,
+		CertFile:           "",
+		KeyFile:            "",
+		RedirectPort:       0,
+		ClientCaFile:       "",
+		ClientCertRequired: true,
+		Http2:              true,
 	}
 
 	err = config.Section("HttpServer").MapTo(httpServerConfigRead)
@@ -39,10 +74,35 @@ func GetHttpServerConfig() (httpServerConfig *HttpServerConfig, err error) {
 		return nil, errors.New("HttpServer: Port missing")
 	}
 
+	if (len(httpServerConfigRead.CertFile) > 0) != (len(httpServerConfigRead.KeyFile) > 0) {
+		return nil, errors.New("HttpServer: CertFile and KeyFile must be set together")
+	}
+
+	if len(httpServerConfigRead.CertFile) < 1 {
+		if httpServerConfigRead.RedirectPort != 0 {
+			return nil, errors.New("HttpServer: RedirectPort requires CertFile and KeyFile")
+		}
+		if len(httpServerConfigRead.ClientCaFile) > 0 {
+			return nil, errors.New("HttpServer: ClientCaFile requires CertFile and KeyFile")
+		}
+	}
+
+	if httpServerConfigRead.RedirectPort == httpServerConfigRead.Port {
+		return nil, errors.New("HttpServer: RedirectPort must differ from Port")
+	}
+
 	httpServerConfig = &HttpServerConfig{
-		Bind:    httpServerConfigRead.Bind,
-		Port:    httpServerConfigRead.Port,
-		LogFile: httpServerConfigRead.LogFile,
+		Bind:       httpServerConfigRead.Bind,
+		Port:       httpServerConfigRead.Port,
+		LogFile:    httpServerConfigRead.LogFile,
+		WsDeadband: httpServerConfigRead.WsDeadband,
+
+		CertFile:           resolvePath(httpServerConfigRead.CertFile),
+		KeyFile:            resolvePath(httpServerConfigRead.KeyFile),
+		RedirectPort:       httpServerConfigRead.RedirectPort,
+		ClientCaFile:       resolvePath(httpServerConfigRead.ClientCaFile),
+		ClientCertRequired: httpServerConfigRead.ClientCertRequired,
+		Http2:              httpServerConfigRead.Http2,
 	}
 
 	httpServerConfig.FrontendConfig = readJsonConfig(httpServerConfigRead.FrontendConfigPath)
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type InfluxDbConfigRead struct {
+	Url             string
+	Version         int
+	Database        string
+	RetentionPolicy string
+	User            string
+	Password        string
+	Org             string
+	Bucket          string
+	Token           string
+	Storage         string
+	AverageInterval string
+	FlushInterval   string
+	BatchSize       int
+	MaxBuffer       int
+}
+
+type InfluxDbConfig struct {
+	// base url of the influxdb server, e.g. http://127.0.0.1:8086
+	Url string
+
+	// 1: write to /write using Database, RetentionPolicy, User and Password
+	// 2: write to /api/v2/write using Org, Bucket and Token
+	Version         int
+	Database        string
+	RetentionPolicy string
+	User            string
+	Password        string
+	Org             string
+	Bucket          string
+	Token           string
+
+	// Rounded or Raw: the storage the values are taken from
+	Storage string
+	// 0: write every change, otherwise write the time weighted average of every value once per interval
+	AverageInterval time.Duration
+
+	// buffered points are written every FlushInterval or as soon as BatchSize points are buffered
+	FlushInterval time.Duration
+	BatchSize     int
+	// while the server is unreachable at most this many points are buffered; the oldest are dropped first
+	MaxBuffer int
+}
+
+func GetInfluxDbConfig() (influxDbConfig *InfluxDbConfig, err error) {
+	influxDbConfigRead := &InfluxDbConfigRead{
+		Url:             "http://127.0.0.1:8086",
+		Version:         1,
+		Database:        "go-ve-sensor",
+		RetentionPolicy: "",
+		User:            "",
+		Password:        "",
+		Org:             "",
+		Bucket:          "",
+		Token:           "",
+		Storage:         "Rounded",
+		AverageInterval: "0s",
+		FlushInterval:   "10s",
+		BatchSize:       1000,
+		MaxBuffer:       100000,
+	}
+
+	// check if influxDb sections exists
+	_, err = config.GetSection("InfluxDb")
+	if err != nil {
+		return nil, errors.New("no influxDb configuration found")
+	}
+
+	err = config.Section("InfluxDb").MapTo(influxDbConfigRead)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read influxDb configuration: %v", err)
+	}
+
+	if len(influxDbConfigRead.Url) < 1 {
+		return nil, errors.New("influxDb: Url not specified")
+	}
+
+	switch influxDbConfigRead.Version {
+	case 1:
+		if len(influxDbConfigRead.Database) < 1 {
+			return nil, errors.New("influxDb: Database not specified")
+		}
+	case 2:
+		if len(influxDbConfigRead.Org) < 1 || len(influxDbConfigRead.Bucket) < 1 {
+			return nil, errors.New("influxDb: Org and Bucket must be specified for Version=2")
+		}
+	default:
+		return nil, fmt.Errorf("influxDb: unknown Version=%v", influxDbConfigRead.Version)
+	}
+
+	if influxDbConfigRead.Storage != "Rounded" && influxDbConfigRead.Storage != "Raw" {
+		return nil, fmt.Errorf("influxDb: unknown Storage=%v", influxDbConfigRead.Storage)
+	}
+
+	averageInterval, err := time.ParseDuration(influxDbConfigRead.AverageInterval)
+	if err != nil || averageInterval < 0 {
+		return nil, fmt.Errorf("influxDb: invalid AverageInterval: %v", influxDbConfigRead.AverageInterval)
+	}
+
+	flushInterval, err := time.ParseDuration(influxDbConfigRead.FlushInterval)
+	if err != nil || flushInterval <= 0 {
+		return nil, fmt.Errorf("influxDb: invalid FlushInterval: %v", influxDbConfigRead.FlushInterval)
+	}
+
+	if influxDbConfigRead.BatchSize < 1 || influxDbConfigRead.MaxBuffer < influxDbConfigRead.BatchSize {
+		return nil, errors.New("influxDb: BatchSize and MaxBuffer must be positive, MaxBuffer >= BatchSize")
+	}
+
+	influxDbConfig = &InfluxDbConfig{
+		Url:             strings.TrimRight(influxDbConfigRead.Url, "/"),
+		Version:         influxDbConfigRead.Version,
+		Database:        influxDbConfigRead.Database,
+		RetentionPolicy: influxDbConfigRead.RetentionPolicy,
+		User:            influxDbConfigRead.User,
+		Password:        influxDbConfigRead.Password,
+		Org:             influxDbConfigRead.Org,
+		Bucket:          influxDbConfigRead.Bucket,
+		Token:           influxDbConfigRead.Token,
+		Storage:         influxDbConfigRead.Storage,
+		AverageInterval: averageInterval,
+		FlushInterval:   flushInterval,
+		BatchSize:       influxDbConfigRead.BatchSize,
+		MaxBuffer:       influxDbConfigRead.MaxBuffer,
+	}
+
+	return influxDbConfig, nil
+}
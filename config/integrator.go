@@ -0,0 +1,53 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type IntegratorConfigRead struct {
+	Values       string
+	StateFile    string
+	SaveInterval string
+}
+
+type IntegratorConfig struct {
+	// names of the power (W) and current (A) values to integrate
+	Values []string
+	// counters are persisted in this file; relative paths are relative to the config file
+	StateFile    string
+	SaveInterval time.Duration
+}
+
+func GetIntegratorConfig() (integratorConfig *IntegratorConfig, err error) {
+	integratorConfigRead := &IntegratorConfigRead{
+		Values:       "Power,Current,PanelPower,ChargerCurrent",
+		StateFile:    "integrator.json",
+		SaveInterval: "1m",
+	}
+
+	// check if integrator sections exists
+	_, err = config.GetSection("Integrator")
+	if err != nil {
+		return nil, errors.New("no integrator configuration found")
+	}
+
+	err = config.Section("Integrator").MapTo(integratorConfigRead)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read integrator configuration: %v", err)
+	}
+
+	saveInterval, err := time.ParseDuration(integratorConfigRead.SaveInterval)
+	if err != nil || saveInterval <= 0 {
+		return nil, fmt.Errorf("integrator: invalid SaveInterval: %v", integratorConfigRead.SaveInterval)
+	}
+
+	integratorConfig = &IntegratorConfig{
+		Values:       splitList(integratorConfigRead.Values),
+		StateFile:    resolvePath(integratorConfigRead.StateFile),
+		SaveInterval: saveInterval,
+	}
+
+	return integratorConfig, nil
+}
@@ -6,9 +6,15 @@ import (
 )
 
 type MongoConfig struct {
-	MongoHost          string
-	DatabaseName       string
+	MongoHost    string
+	DatabaseName string
+
+	// buffered values are inserted every RawValuesIntervall milliseconds or as soon as BatchSize values are buffered
 	RawValuesIntervall int
+	BatchSize          int
+
+	// while the database is unreachable at most this many values are buffered; the oldest are dropped first
+	MaxBuffer int
 }
 
 func GetMongoConfig() (mongoConfig *MongoConfig, err error) {
@@ -16,6 +22,8 @@ func GetMongoConfig() (mongoConfig *MongoConfig, err error) {
 		MongoHost:          "127.0.0.1",
 		DatabaseName:       "go-ve-sensor",
 		RawValuesIntervall: 2000,
+		BatchSize:          256,
+		MaxBuffer:          65536,
 	}
 
 	// check if mongo sections exists
@@ -31,5 +39,9 @@ func GetMongoConfig() (mongoConfig *MongoConfig, err error) {
 		return nil, fmt.Errorf("cannot read mongo configuration: %v", err)
 	}
 
+	if mongoConfig.RawValuesIntervall < 1 || mongoConfig.BatchSize < 1 || mongoConfig.MaxBuffer < mongoConfig.BatchSize {
+		return nil, errors.New("mongo: RawValuesIntervall, BatchSize and MaxBuffer must be positive, MaxBuffer >= BatchSize")
+	}
+
 	return
 }
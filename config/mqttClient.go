@@ -3,55 +3,182 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
 )
 
 type MqttClientConfig struct {
-	Broker            string
-	User              string
-	Password          string
-	ClientId          string
-	Qos               byte
-	DebugLog          bool
-	TopicPrefix       string
-	AvailableEnable   bool
-	AvailableTopic    string
-	TelemetryInterval string
-	TelemetryTopic    string
-	TelemetryRetain   bool
-	RealtimeEnable    bool
-	RealtimeTopic     string
-	RealtimeRetain    bool
+	// empty for the [MqttClient] section, <name> for [MqttClient.<name>] sections
+	Name                  string `ini:"-"`
+	Broker                string
+	User                  string
+	Password              string
+	ClientId              string
+	Qos                   byte `ini:"-"` // read separately since byte fields are not supported by MapTo
+	DebugLog              bool
+	TopicPrefix           string
+	AvailableEnable       bool
+	AvailableTopic        string
+	DeviceAvailableEnable bool
+	DeviceAvailableTopic  string
+	TelemetryInterval     string
+	TelemetryTopic        string
+	TelemetryRetain       bool
+	RealtimeEnable        bool
+	RealtimeTopic         string
+	RealtimeRetain        bool
+	RealtimeDeadband      bool
+	AlarmEnable           bool
+	AlarmTopic            string
+	AlarmRetain           bool
+	// publish retained home assistant mqtt discovery configs for every device and value
+	HassDiscoveryEnable bool
+	HassDiscoveryPrefix string
+	// configs of values which have been stale or not reported for this duration are removed; 0 keeps them
+	HassDiscoveryExpire string
+	// subscribe to command topics to set registers or trigger actions; only allowed commands are executed
+	CommandEnable      bool
+	CommandTopic       string
+	CommandResultTopic string
+	// comma separated list of DeviceName/ValueName entries; * matches any device or value, e.g. 24v-solar/*,*/Reread
+	CommandAllow string
+	// the connection is retried with an exponential back-off up to this interval
+	MaxReconnectInterval string
+	// telemetry messages are queued while the broker is unreachable; the oldest are dropped first, 0 disables the queue
+	TelemetryQueueSize int
+	// optional file the queue is kept in such that it survives a restart
+	TelemetryQueueFile string
+	// used for brokers with the scheme ssl://, tls://, tcps:// or wss://
+	// certificate authorities in PEM format the broker is verified against; default: the system roots
+	CaFile string
+	// client certificate and key in PEM format for brokers requiring mutual tls; reloaded on every connect
+	CertFile string
+	KeyFile  string
+	// do not verify the certificate of the broker; for testing only
+	InsecureSkipVerify bool
+	// Json, Plain (the value only), Influx (line protocol) or Template
+	RealtimeFormat string
+	// go template executed for every value if RealtimeFormat=Template, e.g. {{.Value}} {{.Unit}}
+	RealtimeTemplate string
+	// Json, Influx or Template
+	TelemetryFormat   string
+	TelemetryTemplate string
+	// renames fields of json payloads, e.g. Time:ts,Value:v; fields renamed to - are omitted
+	JsonFields string
+	// format of the time in json and template payloads: Legacy (2006-01-02T15:04:05 in UTC), RFC3339 or EpochMs
+	TimeFormat string
+	// comma separated lists of the device and value names published to this broker; empty: all
+	Devices string
+	Values  string
 }
 
-func GetMqttClientConfig() (mqttClientConfig *MqttClientConfig, err error) {
-	mqttClientConfig = &MqttClientConfig{
-		Broker:            "",
-		User:              "",
-		Password:          "",
-		ClientId:          "go-ve-sensor",
-		Qos:               1,
-		DebugLog:          false,
-		TopicPrefix:       "",
-		AvailableEnable:   true,
-		AvailableTopic:    "%Prefix%tele/%ClientId%/LWT",
-		TelemetryInterval: "10s",
-		TelemetryTopic:    "%Prefix%tele/ve/%DeviceName%",
-		TelemetryRetain:   false,
-		RealtimeEnable:    false,
-		RealtimeTopic:     "%Prefix%stat/ve/%DeviceName%/%ValueName%",
-		RealtimeRetain:    true,
-	}
-
-	// check if mqttClient sections exists
-	_, err = config.GetSection("MqttClient")
-	if err != nil {
+const mqttClientSection = "MqttClient"
+const mqttClientPrefix = mqttClientSection + "."
+
+// GetMqttClientConfigs reads the [MqttClient] section and all [MqttClient.<name>] sections,
+// every section configures a client connecting to its own broker
+func GetMqttClientConfigs() (mqttClientConfigs []*MqttClientConfig, err error) {
+	for _, sectionName := range config.SectionStrings() {
+		if sectionName != mqttClientSection && !strings.HasPrefix(sectionName, mqttClientPrefix) {
+			continue
+		}
+
+		mqttClientConfig, err := GetMqttClientConfig(sectionName)
+		if err != nil {
+			return nil, fmt.Errorf("section %v: %v", sectionName, err)
+		}
+
+		for _, other := range mqttClientConfigs {
+			if other.Broker == mqttClientConfig.Broker && other.ClientId == mqttClientConfig.ClientId {
+				return nil, fmt.Errorf("section %v: Broker and ClientId are already used by another section", sectionName)
+			}
+			if len(other.TelemetryQueueFile) > 0 && other.TelemetryQueueFile == mqttClientConfig.TelemetryQueueFile {
+				return nil, fmt.Errorf("section %v: TelemetryQueueFile is already used by another section", sectionName)
+			}
+		}
+
+		mqttClientConfigs = append(mqttClientConfigs, mqttClientConfig)
+	}
+
+	if len(mqttClientConfigs) < 1 {
 		return nil, errors.New("no mqttClient configuration found")
 	}
 
-	err = config.Section("MqttClient").MapTo(mqttClientConfig)
+	return
+}
+
+func GetMqttClientConfig(sectionName string) (mqttClientConfig *MqttClientConfig, err error) {
+	name := strings.TrimPrefix(sectionName, mqttClientPrefix)
+	clientId := "go-ve-sensor"
+	if sectionName == mqttClientSection {
+		name = ""
+	} else {
+		// allows connecting to the same broker from multiple sections without further configuration
+		clientId += "-" + name
+	}
+
+	mqttClientConfig = &MqttClientConfig{
+		Broker:                "",
+		User:                  "",
+		Password:              "",
+		ClientId:              clientId,
+		Qos:                   1,
+		DebugLog:              false,
+		TopicPrefix:           "",
+		AvailableEnable:       true,
+		AvailableTopic:        "%Prefix%tele/%ClientId%/LWT",
+		DeviceAvailableEnable: true,
+		DeviceAvailableTopic:  "%Prefix%tele/ve/%DeviceName%/LWT",
+		TelemetryInterval:     "10s",
+		TelemetryTopic:        "%Prefix%tele/ve/%DeviceName%",
+		TelemetryRetain:       false,
+		RealtimeEnable:        false,
+		RealtimeTopic:         "%Prefix%stat/ve/%DeviceName%/%ValueName%",
+		RealtimeRetain:        true,
+		RealtimeDeadband:      false,
+		AlarmEnable:           false,
+		AlarmTopic:            "%Prefix%tele/ve/%DeviceName%/Alarm",
+		AlarmRetain:           false,
+		HassDiscoveryEnable:   false,
+		HassDiscoveryPrefix:   "homeassistant",
+		HassDiscoveryExpire:   "24h",
+		CommandEnable:         false,
+		CommandTopic:          "%Prefix%cmnd/ve/%DeviceName%/%ValueName%",
+		CommandResultTopic:    "%Prefix%tele/ve/%DeviceName%/CommandResult",
+		CommandAllow:          "",
+		MaxReconnectInterval:  "1m",
+		TelemetryQueueSize:    10000,
+		TelemetryQueueFile:    "",
+		CaFile:                "",
+		CertFile:              "",
+		KeyFile:               "",
+		InsecureSkipVerify:    false,
+		RealtimeFormat:        "Json",
+		RealtimeTemplate:      "",
+		TelemetryFormat:       "Json",
+		TelemetryTemplate:     "",
+		JsonFields:            "",
+		TimeFormat:            "Legacy",
+		Devices:               "",
+		Values:                "",
+	}
+
+	section := config.Section(sectionName)
+	err = section.MapTo(mqttClientConfig)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read mqttClient configuration: %v", err)
 	}
+	mqttClientConfig.Name = name
+
+	if section.HasKey("Qos") {
+		qos, err := section.Key("Qos").Int()
+		if err != nil || qos < 0 || qos > 2 {
+			return nil, fmt.Errorf("mqttClient: invalid Qos: %v", section.Key("Qos").String())
+		}
+		mqttClientConfig.Qos = byte(qos)
+	}
 
 	if len(mqttClientConfig.Broker) < 1 {
 		return nil, errors.New("mqttClient: Broker not specified")
@@ -61,5 +188,159 @@ func GetMqttClientConfig() (mqttClientConfig *MqttClientConfig, err error) {
 		return nil, errors.New("mqttClient: ClientId not specified")
 	}
 
+	broker, err := url.Parse(mqttClientConfig.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("mqttClient: invalid Broker: %v", err)
+	}
+	switch broker.Scheme {
+	case "tcp", "ws", "ssl", "tls", "tcps", "wss":
+	default:
+		return nil, fmt.Errorf("mqttClient: Broker has unknown scheme=%v, use tcp, ssl or wss", broker.Scheme)
+	}
+
+	if (len(mqttClientConfig.CertFile) > 0) != (len(mqttClientConfig.KeyFile) > 0) {
+		return nil, errors.New("mqttClient: CertFile and KeyFile must be set together")
+	}
+	if !mqttClientConfig.Tls() &&
+		(len(mqttClientConfig.CaFile) > 0 || len(mqttClientConfig.CertFile) > 0 || mqttClientConfig.InsecureSkipVerify) {
+		return nil, errors.New("mqttClient: CaFile, CertFile, KeyFile and InsecureSkipVerify require a ssl:// or wss:// Broker")
+	}
+	mqttClientConfig.CaFile = resolvePath(mqttClientConfig.CaFile)
+	mqttClientConfig.CertFile = resolvePath(mqttClientConfig.CertFile)
+	mqttClientConfig.KeyFile = resolvePath(mqttClientConfig.KeyFile)
+
+	if interval, err := time.ParseDuration(mqttClientConfig.MaxReconnectInterval); err != nil || interval < time.Second {
+		return nil, fmt.Errorf("mqttClient: invalid MaxReconnectInterval: %v", mqttClientConfig.MaxReconnectInterval)
+	}
+
+	if mqttClientConfig.TelemetryQueueSize < 0 {
+		return nil, errors.New("mqttClient: TelemetryQueueSize must not be negative")
+	}
+	mqttClientConfig.TelemetryQueueFile = resolvePath(mqttClientConfig.TelemetryQueueFile)
+
+	if err := checkPayloadFormat("Realtime", mqttClientConfig.RealtimeFormat, mqttClientConfig.RealtimeTemplate, true); err != nil {
+		return nil, err
+	}
+	if err := checkPayloadFormat("Telemetry", mqttClientConfig.TelemetryFormat, mqttClientConfig.TelemetryTemplate, false); err != nil {
+		return nil, err
+	}
+
+	if _, err := mqttClientConfig.JsonFieldNames(); err != nil {
+		return nil, err
+	}
+
+	switch mqttClientConfig.TimeFormat {
+	case "Legacy", "RFC3339", "EpochMs":
+	default:
+		return nil, fmt.Errorf("mqttClient: unknown TimeFormat=%v", mqttClientConfig.TimeFormat)
+	}
+
+	// a misspelled name must not silently publish nothing or, if no name is left, everything
+	for _, deviceName := range mqttClientConfig.DeviceNames() {
+		if !deviceConfigured(deviceName) {
+			return nil, fmt.Errorf("mqttClient: unknown device=%v in Devices", deviceName)
+		}
+	}
+
+	if mqttClientConfig.HassDiscoveryEnable && len(mqttClientConfig.HassDiscoveryPrefix) < 1 {
+		return nil, errors.New("mqttClient: HassDiscoveryPrefix not specified")
+	}
+	if expire, err := time.ParseDuration(mqttClientConfig.HassDiscoveryExpire); err != nil || expire < 0 {
+		return nil, fmt.Errorf("mqttClient: invalid HassDiscoveryExpire: %v", mqttClientConfig.HassDiscoveryExpire)
+	}
+
+	if mqttClientConfig.CommandEnable {
+		levels := strings.Split(mqttClientConfig.CommandTopic, "/")
+		if countLevel(levels, "%DeviceName%") != 1 || countLevel(levels, "%ValueName%") != 1 {
+			return nil, errors.New("mqttClient: CommandTopic must contain %DeviceName% and %ValueName% as topic levels")
+		}
+	}
+
+	return
+}
+
+// DeviceNames returns the devices published to this broker, empty: all
+func (mqttClientConfig *MqttClientConfig) DeviceNames() []string {
+	return splitList(mqttClientConfig.Devices)
+}
+
+// ValueNames returns the values published to this broker, empty: all
+func (mqttClientConfig *MqttClientConfig) ValueNames() []string {
+	return splitList(mqttClientConfig.Values)
+}
+
+// deviceConfigured returns true if there is a [Vedevice.<name>] or [FtpCamera.<name>] section
+func deviceConfigured(name string) bool {
+	if _, err := config.GetSection(vedevicePrefix + name); err == nil {
+		return true
+	}
+	_, err := config.GetSection(ftpCameraPrefix + name)
+	return err == nil
+}
+
+// Tls returns true if the connection to the broker is encrypted
+func (mqttClientConfig *MqttClientConfig) Tls() bool {
+	switch strings.SplitN(mqttClientConfig.Broker, "://", 2)[0] {
+	case "ssl", "tls", "tcps", "wss":
+		return true
+	}
+	return false
+}
+
+func checkPayloadFormat(output, format, tmpl string, plainAllowed bool) error {
+	switch format {
+	case "Json", "Influx":
+		return nil
+	case "Plain":
+		if plainAllowed {
+			return nil
+		}
+	case "Template":
+		if len(tmpl) < 1 {
+			return fmt.Errorf("mqttClient: %vTemplate not specified", output)
+		}
+		// only the syntax is checked here; the functions are provided by the mqttClient
+		funcs := template.FuncMap{"formatTime": func(time.Time) string { return "" }}
+		if _, err := template.New(output).Funcs(funcs).Parse(tmpl); err != nil {
+			return fmt.Errorf("mqttClient: invalid %vTemplate: %v", output, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("mqttClient: unknown %vFormat=%v", output, format)
+}
+
+// JsonFieldNames returns the renamed fields of json payloads as map from the original to the new name
+func (mqttClientConfig *MqttClientConfig) JsonFieldNames() (map[string]string, error) {
+	names := make(map[string]string)
+	for _, entry := range splitList(mqttClientConfig.JsonFields) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || len(parts[0]) < 1 || len(parts[1]) < 1 {
+			return nil, fmt.Errorf("mqttClient: invalid JsonFields entry=%v, use Name:NewName", entry)
+		}
+		names[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return names, nil
+}
+
+// CommandAllowed checks the command against the CommandAllow list
+func (mqttClientConfig *MqttClientConfig) CommandAllowed(deviceName, valueName string) bool {
+	for _, entry := range splitList(mqttClientConfig.CommandAllow) {
+		parts := strings.SplitN(entry, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if (parts[0] == "*" || parts[0] == deviceName) && (parts[1] == "*" || parts[1] == valueName) {
+			return true
+		}
+	}
+	return false
+}
+
+func countLevel(levels []string, level string) (count int) {
+	for _, l := range levels {
+		if l == level {
+			count++
+		}
+	}
 	return
 }
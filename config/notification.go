@@ -0,0 +1,134 @@
+package config
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+type NotificationConfigRead struct {
+	Type        string
+	Rules       string
+	States      string
+	MinInterval string
+
+	// Webhook, Gotify and Ntfy
+	Url          string
+	Token        string
+	BodyTemplate string
+	Priority     int
+
+	// Smtp
+	SmtpHost     string
+	SmtpPort     int
+	SmtpUser     string
+	SmtpPassword string
+	From         string
+	To           string
+}
+
+type NotificationConfig struct {
+	Name string
+
+	// Webhook: POST to Url using BodyTemplate (go text/template, default: the alarm as json)
+	// Smtp:    send an email to every address in To
+	// Gotify:  POST to Url/message using Token as application token
+	// Ntfy:    POST to Url (the topic url) using Token as optional bearer token
+	Type string
+
+	// empty list: notify about every rule
+	Rules []string
+	// alarm states which trigger a notification
+	States []string
+	// notifications for the same rule and device are sent at most once within this interval
+	MinInterval time.Duration
+
+	Url          string
+	Token        string
+	BodyTemplate string
+	// 0: use the default priority of the push server
+	Priority int
+
+	SmtpHost     string
+	SmtpPort     int
+	SmtpUser     string
+	SmtpPassword string
+	From         string
+	To           []string
+}
+
+const notificationPrefix = "Notification."
+
+func GetNotificationConfig(sectionName string) (notificationConfig *NotificationConfig) {
+	notificationConfigRead := &NotificationConfigRead{
+		Type:         "Webhook",
+		Rules:        "",
+		States:       "Raised,Cleared",
+		MinInterval:  "5m",
+		Url:          "",
+		Token:        "",
+		BodyTemplate: "",
+		Priority:     0,
+		SmtpHost:     "127.0.0.1",
+		SmtpPort:     25,
+		SmtpUser:     "",
+		SmtpPassword: "",
+		From:         "go-ve-sensor@localhost",
+		To:           "",
+	}
+
+	err := config.Section(sectionName).MapTo(notificationConfigRead)
+	if err != nil {
+		log.Fatalf("config: cannot read notification configuration: %v", err)
+	}
+
+	switch notificationConfigRead.Type {
+	case "Webhook", "Gotify", "Ntfy":
+		if len(notificationConfigRead.Url) < 1 {
+			log.Fatalf("config: notification Url missing in section %v", sectionName)
+		}
+	case "Smtp":
+		if len(notificationConfigRead.To) < 1 {
+			log.Fatalf("config: notification To missing in section %v", sectionName)
+		}
+	default:
+		log.Fatalf("config: unknown notification Type=%v in section %v", notificationConfigRead.Type, sectionName)
+	}
+
+	minInterval, err := time.ParseDuration(notificationConfigRead.MinInterval)
+	if err != nil {
+		log.Fatalf("config: cannot parse notification MinInterval in section %v: %v", sectionName, err)
+	}
+
+	notificationConfig = &NotificationConfig{
+		Name:         sectionName[len(notificationPrefix):],
+		Type:         notificationConfigRead.Type,
+		Rules:        splitList(notificationConfigRead.Rules),
+		States:       splitList(notificationConfigRead.States),
+		MinInterval:  minInterval,
+		Url:          notificationConfigRead.Url,
+		Token:        notificationConfigRead.Token,
+		BodyTemplate: notificationConfigRead.BodyTemplate,
+		Priority:     notificationConfigRead.Priority,
+		SmtpHost:     notificationConfigRead.SmtpHost,
+		SmtpPort:     notificationConfigRead.SmtpPort,
+		SmtpUser:     notificationConfigRead.SmtpUser,
+		SmtpPassword: notificationConfigRead.SmtpPassword,
+		From:         notificationConfigRead.From,
+		To:           splitList(notificationConfigRead.To),
+	}
+
+	return
+}
+
+func GetNotificationConfigs() (notificationConfigs []*NotificationConfig) {
+	sections := config.SectionStrings()
+	for _, sectionName := range sections {
+		if !strings.HasPrefix(sectionName, notificationPrefix) {
+			continue
+		}
+		notificationConfigs = append(notificationConfigs, GetNotificationConfig(sectionName))
+	}
+
+	return
+}
@@ -0,0 +1,176 @@
+package dataflow
+
+import (
+	"github.com/koestler/go-ve-sensor/storage"
+	"math"
+	"time"
+)
+
+// Deadband is a pipeline stage which suppresses insignificant changes and limits the publish rate per value.
+// Every consumer should use its own instance since the state depends on what has been forwarded.
+type Deadband struct {
+	input, output chan Value
+
+	rules *DeadbandRules
+
+	// this represents the state of the deadband and must only be access by the main go routine
+	values map[deadbandKey]*deadbandState
+}
+
+type DeadbandRule struct {
+	// a new value is only forwarded if it differs by at least Absolute or Percent from the last forwarded one
+	// both 0: every change is forwarded
+	Absolute float64
+	Percent  float64
+	// forward at most one value within MinInterval
+	MinInterval time.Duration
+	// repeat the last value after MaxInterval even if unchanged; 0 disables it
+	MaxInterval time.Duration
+}
+
+type DeadbandRules struct {
+	// used for every value without its own rule
+	Default DeadbandRule
+	// value name -> rule
+	Rules map[string]DeadbandRule
+}
+
+type deadbandKey struct {
+	device *storage.Device
+	name   string
+}
+
+type deadbandState struct {
+	rule     DeadbandRule
+	sent     Value
+	sentTime time.Time
+	latest   Value
+	pending  bool
+}
+
+const deadbandTickInterval = 250 * time.Millisecond
+
+func DeadbandCreate(rules *DeadbandRules) *Deadband {
+	deadband := Deadband{
+		input:  make(chan Value),
+		output: make(chan Value),
+		rules:  rules,
+		values: make(map[deadbandKey]*deadbandState),
+	}
+
+	go deadband.mainDeadbandRoutine()
+
+	return &deadband
+}
+
+func (deadband *Deadband) mainDeadbandRoutine() {
+	defer close(deadband.output)
+
+	ticker := time.NewTicker(deadbandTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case value, ok := <-deadband.input:
+			if !ok {
+				return
+			}
+			deadband.handleNewValue(value, time.Now())
+		case now := <-ticker.C:
+			deadband.handleTick(now)
+		}
+	}
+}
+
+func (deadband *Deadband) handleNewValue(value Value, now time.Time) {
+	key := deadbandKey{device: value.Device, name: value.Name}
+
+	state, ok := deadband.values[key]
+	if !ok {
+		// first value is always forwarded
+		deadband.values[key] = &deadbandState{
+			rule:     deadband.getRule(value.Name),
+			sent:     value,
+			sentTime: now,
+			latest:   value,
+		}
+		deadband.output <- value
+		return
+	}
+
+	state.latest = value
+
+	// staleness changes are always forwarded immediately
+	if value.Stale != state.sent.Stale || (state.significant(value) && now.Sub(state.sentTime) >= state.rule.MinInterval) {
+		deadband.send(state, now)
+		return
+	}
+
+	// remember the value to send it once MinInterval has passed
+	state.pending = state.significant(value)
+}
+
+func (deadband *Deadband) handleTick(now time.Time) {
+	for _, state := range deadband.values {
+		elapsed := now.Sub(state.sentTime)
+
+		if state.pending && elapsed >= state.rule.MinInterval {
+			deadband.send(state, now)
+		} else if state.rule.MaxInterval > 0 && elapsed >= state.rule.MaxInterval {
+			deadband.send(state, now)
+		}
+	}
+}
+
+func (deadband *Deadband) send(state *deadbandState, now time.Time) {
+	state.sent = state.latest
+	state.sentTime = now
+	state.pending = false
+	deadband.output <- state.latest
+}
+
+func (deadband *Deadband) getRule(valueName string) DeadbandRule {
+	if rule, ok := deadband.rules.Rules[valueName]; ok {
+		return rule
+	}
+	return deadband.rules.Default
+}
+
+func (state *deadbandState) significant(value Value) bool {
+	diff := math.Abs(value.Value - state.sent.Value)
+	if diff == 0 {
+		return false
+	}
+
+	if state.rule.Absolute <= 0 && state.rule.Percent <= 0 {
+		return true
+	}
+
+	if state.rule.Absolute > 0 && diff >= state.rule.Absolute {
+		return true
+	}
+
+	if state.rule.Percent > 0 && diff >= math.Abs(state.sent.Value)*state.rule.Percent/100 {
+		return true
+	}
+
+	return false
+}
+
+func (deadband *Deadband) Fill(input <-chan Value) {
+	go func() {
+		defer close(deadband.input)
+		for value := range input {
+			deadband.input <- value
+		}
+	}()
+}
+
+func (deadband *Deadband) Drain() <-chan Value {
+	return deadband.output
+}
+
+func (deadband *Deadband) Append(fillable Fillable) Fillable {
+	fillable.Fill(deadband.Drain())
+	return fillable
+}
@@ -0,0 +1,276 @@
+package dataflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/storage"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Integrator integrates power (W) and current (A) values over time and outputs
+// daily, weekly, monthly and total energy (Wh) and charge (Ah) counters.
+// The input value is held until the next update or until it becomes stale.
+type Integrator struct {
+	input, output chan Value
+
+	stateFile    string
+	saveInterval time.Duration
+
+	// this represents the state of the integrator and must only be access by the main go routine
+	counters map[string]*integratorCounter
+
+	saveRequest chan chan struct{}
+}
+
+type integratorCounter struct {
+	// persisted state
+	integratorTotals
+
+	// hold state; the last value is integrated from holdTime until the next update
+	device    *storage.Device
+	name      string
+	unit      string
+	holdValue float64
+	holdTime  time.Time
+	active    bool
+
+	// the totals at the last update of the source; the ticks since then are reverted when the source becomes stale
+	updateTotals integratorTotals
+	updateTime   time.Time
+}
+
+type integratorTotals struct {
+	Day   integratorPeriod
+	Week  integratorPeriod
+	Month integratorPeriod
+	Total float64
+}
+
+type integratorPeriod struct {
+	Key   string
+	Value float64
+}
+
+const integratorTickInterval = 10 * time.Second
+
+func IntegratorCreate(stateFile string, saveInterval time.Duration) *Integrator {
+	integrator := Integrator{
+		input:        make(chan Value),
+		output:       make(chan Value, 16),
+		stateFile:    stateFile,
+		saveInterval: saveInterval,
+		counters:     make(map[string]*integratorCounter),
+		saveRequest:  make(chan chan struct{}),
+	}
+
+	integrator.load()
+
+	go integrator.mainIntegratorRoutine()
+
+	return &integrator
+}
+
+func (integrator *Integrator) mainIntegratorRoutine() {
+	tick := time.NewTicker(integratorTickInterval)
+	defer tick.Stop()
+
+	save := time.NewTicker(integrator.saveInterval)
+	defer save.Stop()
+
+	for {
+		select {
+		case value := <-integrator.input:
+			integrator.handleNewValue(value)
+		case now := <-tick.C:
+			for _, counter := range integrator.counters {
+				if counter.active {
+					counter.accumulate(now)
+					integrator.emit(counter)
+				}
+			}
+		case <-save.C:
+			integrator.save()
+		case done := <-integrator.saveRequest:
+			// include the energy accumulated since the last update
+			now := time.Now()
+			for _, counter := range integrator.counters {
+				if counter.active {
+					counter.accumulate(now)
+				}
+			}
+			integrator.save()
+			close(done)
+		}
+	}
+}
+
+func (integrator *Integrator) handleNewValue(value Value) {
+	if value.Unit != "W" && value.Unit != "A" {
+		return
+	}
+
+	key := value.Device.Name + "/" + value.Name
+	counter, ok := integrator.counters[key]
+	if !ok {
+		counter = &integratorCounter{}
+		integrator.counters[key] = counter
+	}
+	counter.device = value.Device
+	counter.name = value.Name
+	counter.unit = value.Unit
+
+	if value.Stale {
+		// the source stopped; integrate only up to its last update
+		if counter.active {
+			counter.integratorTotals = counter.updateTotals
+			counter.holdTime = counter.updateTime
+			counter.accumulate(value.Time)
+			counter.active = false
+			integrator.emit(counter)
+		}
+		return
+	}
+
+	now := value.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if counter.active {
+		counter.accumulate(now)
+	}
+	counter.holdValue = value.Value
+	counter.holdTime = now
+	counter.active = true
+	counter.updateTotals = counter.integratorTotals
+	counter.updateTime = now
+
+	integrator.emit(counter)
+}
+
+func (counter *integratorCounter) accumulate(until time.Time) {
+	if !until.After(counter.holdTime) {
+		return
+	}
+
+	delta := counter.holdValue * until.Sub(counter.holdTime).Hours()
+	counter.holdTime = until
+
+	counter.Day.add(until.Format("2006-01-02"), delta)
+	year, week := until.ISOWeek()
+	counter.Week.add(fmt.Sprintf("%04d-W%02d", year, week), delta)
+	counter.Month.add(until.Format("2006-01"), delta)
+	counter.Total += delta
+}
+
+// add resets the period when a new one has started
+func (period *integratorPeriod) add(key string, delta float64) {
+	if period.Key != key {
+		period.Key = key
+		period.Value = 0
+	}
+	period.Value += delta
+}
+
+func (integrator *Integrator) emit(counter *integratorCounter) {
+	if counter.device == nil {
+		// restored from the state file but not updated yet
+		return
+	}
+
+	name := integratedName(counter.name, counter.unit)
+	unit := counter.unit + "h"
+
+	for suffix, v := range map[string]float64{
+		"Today":     counter.Day.Value,
+		"ThisWeek":  counter.Week.Value,
+		"ThisMonth": counter.Month.Value,
+		"Total":     counter.Total,
+	} {
+		integrator.output <- Value{
+			Device:        counter.device,
+			Name:          name + suffix,
+			Value:         v,
+			Unit:          unit,
+			RoundDecimals: 1,
+		}
+	}
+}
+
+// integratedName converts eg. PanelPower into PanelEnergy and ChargerCurrent into ChargerCharge
+func integratedName(name, unit string) string {
+	from, to := "Power", "Energy"
+	if unit == "A" {
+		from, to = "Current", "Charge"
+	}
+	return strings.TrimSuffix(name, from) + to
+}
+
+func (integrator *Integrator) load() {
+	if len(integrator.stateFile) < 1 {
+		return
+	}
+
+	b, err := ioutil.ReadFile(integrator.stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("integrator: cannot read state file: %v", err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(b, &integrator.counters); err != nil {
+		log.Printf("integrator: cannot decode state file: %v", err)
+		integrator.counters = make(map[string]*integratorCounter)
+	}
+}
+
+func (integrator *Integrator) save() {
+	if len(integrator.stateFile) < 1 {
+		return
+	}
+
+	b, err := json.MarshalIndent(integrator.counters, "", "    ")
+	if err != nil {
+		log.Printf("integrator: cannot encode state: %v", err)
+		return
+	}
+
+	// write to a temporary file first such that a crash never leaves a truncated state file
+	tmpFile := integrator.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, b, 0644); err != nil {
+		log.Printf("integrator: cannot write state file: %v", err)
+		return
+	}
+	if err := os.Rename(tmpFile, integrator.stateFile); err != nil {
+		log.Printf("integrator: cannot write state file: %v", err)
+	}
+}
+
+// Save writes the counters immediately and returns once they are written, e.g. before shutting down
+func (integrator *Integrator) Save() {
+	done := make(chan struct{})
+	integrator.saveRequest <- done
+	<-done
+}
+
+func (integrator *Integrator) Fill(input <-chan Value) {
+	go func() {
+		for value := range input {
+			integrator.input <- value
+		}
+	}()
+}
+
+func (integrator *Integrator) Drain() <-chan Value {
+	return integrator.output
+}
+
+func (integrator *Integrator) Append(fillable Fillable) Fillable {
+	fillable.Fill(integrator.Drain())
+	return fillable
+}
@@ -0,0 +1,147 @@
+package dataflow
+
+import (
+	"encoding/json"
+	"github.com/koestler/go-ve-sensor/storage"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// StateSnapshot periodically saves the state of a storage to a file and restores it on startup.
+// Restored values are stale and flagged as restored until their source sends an update.
+type StateSnapshot struct {
+	storage  *ValueStorageInstance
+	file     string
+	interval time.Duration
+
+	saveRequest chan chan struct{}
+}
+
+// persisted format: device name -> value name -> value
+type snapshotState map[string]map[string]snapshotValue
+
+type snapshotValue struct {
+	Value         float64
+	Unit          string
+	RoundDecimals int
+	Time          time.Time
+}
+
+func StateSnapshotCreate(valueStorage *ValueStorageInstance, file string, interval time.Duration) *StateSnapshot {
+	snapshot := StateSnapshot{
+		storage:     valueStorage,
+		file:        file,
+		interval:    interval,
+		saveRequest: make(chan chan struct{}),
+	}
+
+	snapshot.restore()
+
+	go snapshot.mainSnapshotRoutine()
+
+	return &snapshot
+}
+
+func (snapshot *StateSnapshot) mainSnapshotRoutine() {
+	save := time.NewTicker(snapshot.interval)
+	defer save.Stop()
+
+	for {
+		select {
+		case <-save.C:
+			snapshot.save()
+		case done := <-snapshot.saveRequest:
+			snapshot.save()
+			close(done)
+		}
+	}
+}
+
+// Save writes the current state immediately and returns once it is written, e.g. before shutting down
+func (snapshot *StateSnapshot) Save() {
+	done := make(chan struct{})
+	snapshot.saveRequest <- done
+	<-done
+}
+
+// restore sends all values of the snapshot file of known devices to the storage
+func (snapshot *StateSnapshot) restore() {
+	b, err := ioutil.ReadFile(snapshot.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("stateSnapshot: cannot read snapshot file: %v", err)
+		}
+		return
+	}
+
+	var state snapshotState
+	if err := json.Unmarshal(b, &state); err != nil {
+		log.Printf("stateSnapshot: cannot decode snapshot file: %v", err)
+		return
+	}
+
+	values := make([]Value, 0)
+	for deviceName, valueMap := range state {
+		device, err := storage.GetByName(deviceName)
+		if err != nil {
+			// device has been removed from the configuration
+			continue
+		}
+		for name, v := range valueMap {
+			values = append(values, Value{
+				Device:        device,
+				Name:          name,
+				Value:         v.Value,
+				Unit:          v.Unit,
+				RoundDecimals: v.RoundDecimals,
+				Time:          v.Time,
+				Stale:         true,
+				Restored:      true,
+			})
+		}
+	}
+
+	log.Printf("stateSnapshot: restore %v values", len(values))
+
+	output := make(chan Value)
+	snapshot.storage.Fill(output)
+	go func() {
+		defer close(output)
+		for _, value := range values {
+			output <- value
+		}
+	}()
+}
+
+func (snapshot *StateSnapshot) save() {
+	state := make(snapshotState)
+	for device, valueMap := range snapshot.storage.GetState(Filter{}) {
+		state[device.Name] = make(map[string]snapshotValue, len(valueMap))
+		for name, value := range valueMap {
+			state[device.Name][name] = snapshotValue{
+				Value:         value.Value,
+				Unit:          value.Unit,
+				RoundDecimals: value.RoundDecimals,
+				Time:          value.Time,
+			}
+		}
+	}
+
+	b, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		log.Printf("stateSnapshot: cannot encode state: %v", err)
+		return
+	}
+
+	// write to a temporary file first such that a crash never leaves a truncated snapshot file
+	tmpFile := snapshot.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, b, 0644); err != nil {
+		log.Printf("stateSnapshot: cannot write snapshot file: %v", err)
+		return
+	}
+	if err := os.Rename(tmpFile, snapshot.file); err != nil {
+		log.Printf("stateSnapshot: cannot write snapshot file: %v", err)
+	}
+}
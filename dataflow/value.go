@@ -1,6 +1,9 @@
 package dataflow
 
-import "github.com/koestler/go-ve-sensor/storage"
+import (
+	"github.com/koestler/go-ve-sensor/storage"
+	"time"
+)
 
 type Value struct {
 	Device        *storage.Device
@@ -8,13 +11,22 @@ type Value struct {
 	Value         float64
 	Unit          string
 	RoundDecimals int
+
+	// time of the last update received from the source
+	Time time.Time
+	// set when no update has been received within the configured stale timeout
+	Stale bool
+	// set for values loaded from the state snapshot; those are stale until the source sends an update
+	Restored bool
 }
 
 type ValueMap map[string]Value
 
 type ValueEssential struct {
-	Value float64
-	Unit  string
+	Value    float64
+	Unit     string
+	Stale    bool
+	Restored bool
 }
 
 type ValueEssentialMap map[string]ValueEssential
@@ -27,9 +39,35 @@ func (valueMap ValueMap) ConvertToEssential() (valueEssentialMap ValueEssentialM
 	return
 }
 
+// Stale returns true if the map contains values and all of them are stale
+func (valueMap ValueMap) Stale() bool {
+	if len(valueMap) < 1 {
+		return false
+	}
+	for _, v := range valueMap {
+		if !v.Stale {
+			return false
+		}
+	}
+	return true
+}
+
 func (value Value) ConvertToEssential() (ValueEssential) {
 	return ValueEssential{
-		Value: value.Value,
-		Unit:  value.Unit,
+		Value:    value.Value,
+		Unit:     value.Unit,
+		Stale:    value.Stale,
+		Restored: value.Restored,
 	}
 }
+
+// Equals compares two values ignoring their update time
+func (value Value) Equals(other Value) bool {
+	return value.Device == other.Device &&
+		value.Name == other.Name &&
+		value.Value == other.Value &&
+		value.Unit == other.Unit &&
+		value.RoundDecimals == other.RoundDecimals &&
+		value.Stale == other.Stale &&
+		value.Restored == other.Restored
+}
@@ -1,6 +1,9 @@
 package dataflow
 
-import "github.com/koestler/go-ve-sensor/storage"
+import (
+	"github.com/koestler/go-ve-sensor/storage"
+	"time"
+)
 
 type State map[*storage.Device]ValueMap
 
@@ -11,9 +14,13 @@ type ValueStorageInstance struct {
 	state         State
 	subscriptions []subscription
 
+	// values not updated within this duration are marked as stale; 0 disables stale detection
+	staleTimeout time.Duration
+
 	// communication channels to/from the main go routine
 	inputChannel            chan Value
 	subscriptionChannel     chan *subscription
+	unsubscriptionChannel   chan (<-chan Value)
 	readStateRequestChannel chan *readStateRequest
 }
 
@@ -33,12 +40,24 @@ type readStateRequest struct {
 }
 
 func (instance *ValueStorageInstance) mainStorageRoutine() {
+	// a nil channel blocks forever -> stale check is disabled
+	var staleCheck <-chan time.Time
+	if instance.staleTimeout > 0 {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		staleCheck = ticker.C
+	}
+
 	for {
 		select {
 		case newValue := <-instance.inputChannel:
 			instance.handleNewValue(newValue)
+		case now := <-staleCheck:
+			instance.handleStaleCheck(now)
 		case newSubscription := <-instance.subscriptionChannel:
 			instance.subscriptions = append(instance.subscriptions, *newSubscription)
+		case output := <-instance.unsubscriptionChannel:
+			instance.handleUnsubscribe(output)
 		case newReadStateRequest := <-instance.readStateRequestChannel:
 			instance.handleNewReadStateRequest(newReadStateRequest)
 		}
@@ -46,18 +65,54 @@ func (instance *ValueStorageInstance) mainStorageRoutine() {
 }
 
 func (instance *ValueStorageInstance) handleNewValue(newValue Value) {
+	if newValue.Time.IsZero() {
+		newValue.Time = time.Now()
+	}
+
 	// check if the newValue is not present or has been changed
 	if _, ok := instance.state[newValue.Device]; !ok {
 		instance.state[newValue.Device] = make(ValueMap)
 	}
-	if currentValue, ok := instance.state[newValue.Device][newValue.Name]; !ok || currentValue != newValue {
+
+	// restored values must never overwrite values received from a source
+	if _, ok := instance.state[newValue.Device][newValue.Name]; ok && newValue.Restored {
+		return
+	}
+	if currentValue, ok := instance.state[newValue.Device][newValue.Name]; !ok || !currentValue.Equals(newValue) {
 		// copy the input value to all subscribed output channels
 		for _, subscription := range instance.subscriptions {
 			subscription.forward(newValue)
 		}
+	}
+
+	// always save the new state to keep track of the last update time
+	instance.state[newValue.Device][newValue.Name] = newValue
+}
+
+func (instance *ValueStorageInstance) handleUnsubscribe(output <-chan Value) {
+	for i, subscription := range instance.subscriptions {
+		if subscription.outputChannel == output {
+			close(subscription.outputChannel)
+			instance.subscriptions = append(instance.subscriptions[:i], instance.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+func (instance *ValueStorageInstance) handleStaleCheck(now time.Time) {
+	for _, deviceState := range instance.state {
+		for valueName, value := range deviceState {
+			if value.Stale || now.Sub(value.Time) < instance.staleTimeout {
+				continue
+			}
 
-		// and save the new state
-		instance.state[newValue.Device][newValue.Name] = newValue
+			// emit a staleness event through the pipeline
+			value.Stale = true
+			for _, subscription := range instance.subscriptions {
+				subscription.forward(value)
+			}
+			deviceState[valueName] = value
+		}
 	}
 }
 
@@ -85,11 +140,13 @@ func (instance *ValueStorageInstance) handleNewReadStateRequest(newReadStateRequ
 	newReadStateRequest.response <- response
 }
 
-func ValueStorageCreate() (valueStorageInstance *ValueStorageInstance) {
+func ValueStorageCreate(staleTimeout time.Duration) (valueStorageInstance *ValueStorageInstance) {
 	valueStorageInstance = &ValueStorageInstance{
 		state:                   make(State),
+		staleTimeout:            staleTimeout,
 		inputChannel:            make(chan Value, 32), // input channel is buffered
 		subscriptionChannel:     make(chan *subscription),
+		unsubscriptionChannel:   make(chan (<-chan Value)),
 		readStateRequestChannel: make(chan *readStateRequest),
 	}
 
@@ -150,6 +207,12 @@ func (instance *ValueStorageInstance) Subscribe(filter Filter) <-chan Value {
 	return output
 }
 
+// Unsubscribe removes the subscription and closes its channel. The subscriber must keep reading
+// from the channel until it is closed since the storage may be blocked sending a value to it.
+func (instance *ValueStorageInstance) Unsubscribe(output <-chan Value) {
+	instance.unsubscriptionChannel <- output
+}
+
 func (instance *ValueStorageInstance) Append(fillable Fillable) Fillable {
 	fillable.Fill(instance.Drain())
 	return fillable
@@ -181,6 +244,11 @@ func filterValue(filter *Filter, value *Value) bool {
 	return filterByDevice(filter, value.Device) && filterByValueName(filter, value.Name)
 }
 
+// Matches returns true if the value passes the filter
+func (filter Filter) Matches(value Value) bool {
+	return filterValue(&filter, &value)
+}
+
 func (subscription subscription) forward(newValue Value) {
 	filter := subscription.filter
 
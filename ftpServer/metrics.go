@@ -0,0 +1,11 @@
+package ftpServer
+
+import (
+	"github.com/koestler/go-ve-sensor/metrics"
+)
+
+var uploads = metrics.CounterVecCreate(
+	"vesensor_ftpserver_uploads_total",
+	"Number of pictures uploaded by a camera.",
+	"device",
+)
@@ -38,4 +38,5 @@ func onFileClose(vf *VirtualFile) {
 		JpegRaw:   vf.buffer,
 	}
 	storage.PictureDb.SetPicture(vf.device, &picture)
+	uploads.Inc(vf.device.Name)
 }
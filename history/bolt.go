@@ -0,0 +1,486 @@
+package history
+
+import (
+	"encoding/binary"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"go.etcd.io/bbolt"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+// BoltStorageInstance stores the history in an embedded bolt database file.
+// Every tier is a top level bucket containing one bucket per device / value.
+// Keys are big endian unix nano timestamps; values contain the float64 bits and a stale flag.
+type BoltStorageInstance struct {
+	db                 *bbolt.DB
+	tiers              []config.HistoryTierConfig
+	compactionInterval time.Duration
+
+	// this represents the state of the storage instance and must only be access by the main go routine
+	pending map[string]map[string]*pendingBucket
+	units   map[string]string
+	batch   []boltWrite
+
+	// communication channels to/from the main go routine
+	inputChannel chan dataflow.Value
+
+	// complete batches are written by the writer go routine such that the main go routine never waits for the disk
+	writeChannel chan []boltWrite
+}
+
+// pendingBucket is an aggregation bucket which is not complete yet
+type pendingBucket struct {
+	start   time.Time
+	end     time.Time
+	average timeAverage
+
+	// the last value is held from since until the next value or the end of the bucket; not held while stale
+	held  bool
+	value float64
+	since time.Time
+}
+
+// holdUntil adds the held value to the average up to the given time, at most up to the end of the bucket
+func (bucket *pendingBucket) holdUntil(t time.Time) {
+	if t.After(bucket.end) {
+		t = bucket.end
+	}
+	if !t.After(bucket.since) {
+		return
+	}
+	if bucket.held {
+		bucket.average.add(bucket.value, t.Sub(bucket.since))
+	}
+	bucket.since = t
+}
+
+// next returns the following bucket which starts with the value held at the end of this one
+func (bucket *pendingBucket) next() *pendingBucket {
+	return &pendingBucket{
+		start: bucket.end,
+		end:   bucket.end.Add(bucket.end.Sub(bucket.start)),
+		held:  bucket.held,
+		value: bucket.value,
+		since: bucket.end,
+	}
+}
+
+// boltWrite is either a sample of a tier or, if tier is empty, a unit update
+type boltWrite struct {
+	tier   string
+	series string
+	sample sample
+	unit   string
+}
+
+var unitsBucket = []byte("units")
+
+const boltFlushInterval = time.Second
+const boltBatchSize = 1024
+const boltWriteQueueSize = 16
+const boltCompactionChunkSize = 4096
+
+func BoltStorageCreate(
+	file string,
+	tiers []config.HistoryTierConfig,
+	compactionInterval time.Duration,
+) (instance *BoltStorageInstance, err error) {
+	db, err := bbolt.Open(file, 0644, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(unitsBucket); err != nil {
+			return err
+		}
+		for _, tier := range tiers {
+			if _, err := tx.CreateBucketIfNotExists([]byte(tier.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	instance = &BoltStorageInstance{
+		db:                 db,
+		tiers:              tiers,
+		compactionInterval: compactionInterval,
+		pending:            make(map[string]map[string]*pendingBucket),
+		units:              make(map[string]string),
+		batch:              make([]boltWrite, 0, boltBatchSize),
+		inputChannel:       make(chan dataflow.Value, 32), // input channel is buffered
+		writeChannel:       make(chan []boltWrite, boltWriteQueueSize),
+	}
+
+	// start main go routine
+	go instance.mainStorageRoutine()
+	go instance.writerRoutine()
+	go instance.compactionRoutine()
+
+	return instance, nil
+}
+
+func (instance *BoltStorageInstance) mainStorageRoutine() {
+	flushTicker := time.NewTicker(boltFlushInterval)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case newValue := <-instance.inputChannel:
+			instance.handleNewValue(newValue)
+			if len(instance.batch) >= boltBatchSize {
+				instance.handOverBatch()
+			}
+		case now := <-flushTicker.C:
+			instance.flushPending(now)
+			instance.handOverBatch()
+		}
+	}
+}
+
+// handOverBatch passes the current batch to the writer go routine; the batch is dropped if the writer cannot keep up
+func (instance *BoltStorageInstance) handOverBatch() {
+	if len(instance.batch) < 1 {
+		return
+	}
+
+	select {
+	case instance.writeChannel <- instance.batch:
+	default:
+		log.Printf("history: writer is too slow, drop batch of %v samples", len(instance.batch))
+		droppedSamples.Add(float64(len(instance.batch)), "Bolt")
+	}
+
+	// the handed over batch is owned by the writer go routine now
+	instance.batch = make([]boltWrite, 0, boltBatchSize)
+}
+
+func (instance *BoltStorageInstance) writerRoutine() {
+	for batch := range instance.writeChannel {
+		instance.writeBatch(batch)
+	}
+}
+
+func (instance *BoltStorageInstance) compactionRoutine() {
+	compactionTicker := time.NewTicker(instance.compactionInterval)
+	defer compactionTicker.Stop()
+
+	// apply retention right after startup
+	instance.compact(time.Now())
+
+	for now := range compactionTicker.C {
+		instance.compact(now)
+	}
+}
+
+func (instance *BoltStorageInstance) handleNewValue(value dataflow.Value) {
+	series := seriesName(value.Device.Name, value.Name)
+
+	t := value.Time
+	if t.IsZero() || value.Stale {
+		// the time of a stale value is the time of its last update; the gap starts now
+		t = time.Now()
+	}
+
+	if instance.units[series] != value.Unit {
+		instance.units[series] = value.Unit
+		instance.batch = append(instance.batch, boltWrite{series: series, unit: value.Unit})
+	}
+
+	if _, ok := instance.pending[series]; !ok {
+		instance.pending[series] = make(map[string]*pendingBucket)
+	}
+
+	s := sample{Time: t, Value: value.Value, Stale: value.Stale}
+
+	for _, tier := range instance.tiers {
+		if tier.Resolution <= 0 {
+			instance.batch = append(instance.batch, boltWrite{tier: tier.Name, series: series, sample: s})
+			continue
+		}
+
+		// complete the buckets which ended before this value; the held value is carried into the next bucket
+		bucket := instance.pending[series][tier.Name]
+		for bucket != nil && !t.Before(bucket.end) {
+			bucket = instance.finishBucket(tier, series, bucket)
+		}
+
+		if value.Stale {
+			if bucket != nil {
+				bucket.holdUntil(t)
+				bucket.held = false
+			}
+			instance.batch = append(instance.batch, boltWrite{tier: tier.Name, series: series, sample: s})
+			continue
+		}
+
+		if bucket == nil {
+			start := t.Truncate(tier.Resolution)
+			bucket = &pendingBucket{start: start, end: start.Add(tier.Resolution), since: t}
+			instance.pending[series][tier.Name] = bucket
+		}
+		bucket.holdUntil(t)
+		bucket.held = true
+		bucket.value = value.Value
+	}
+}
+
+// flushPending writes all aggregation buckets which are complete
+func (instance *BoltStorageInstance) flushPending(now time.Time) {
+	for _, tier := range instance.tiers {
+		for series, buckets := range instance.pending {
+			bucket := buckets[tier.Name]
+			for bucket != nil && !now.Before(bucket.end) {
+				bucket = instance.finishBucket(tier, series, bucket)
+			}
+		}
+	}
+}
+
+// finishBucket writes the time weighted average of a complete bucket and returns the following bucket
+// which is nil if no value is held anymore
+func (instance *BoltStorageInstance) finishBucket(tier config.HistoryTierConfig, series string, bucket *pendingBucket) *pendingBucket {
+	bucket.holdUntil(bucket.end)
+	if value, ok := bucket.average.value(); ok {
+		instance.batch = append(instance.batch, boltWrite{
+			tier:   tier.Name,
+			series: series,
+			sample: sample{Time: bucket.start, Value: value},
+		})
+	}
+
+	if !bucket.held {
+		delete(instance.pending[series], tier.Name)
+		return nil
+	}
+	next := bucket.next()
+	instance.pending[series][tier.Name] = next
+	return next
+}
+
+func (instance *BoltStorageInstance) writeBatch(batch []boltWrite) {
+	err := instance.db.Update(func(tx *bbolt.Tx) error {
+		for _, write := range batch {
+			if len(write.tier) < 1 {
+				// unit update
+				if err := tx.Bucket(unitsBucket).Put([]byte(write.series), []byte(write.unit)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			seriesBucket, err := tx.Bucket([]byte(write.tier)).CreateBucketIfNotExists([]byte(write.series))
+			if err != nil {
+				return err
+			}
+			if err := seriesBucket.Put(encodeTime(write.sample.Time), encodeSample(write.sample)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("history: cannot write batch of %v samples: %v", len(batch), err)
+	}
+}
+
+// compact deletes all samples older than the retention of their tier
+func (instance *BoltStorageInstance) compact(now time.Time) {
+	start := time.Now()
+	stored := make(map[string]int, len(instance.tiers))
+	total := 0
+
+	var err error
+	for _, tier := range instance.tiers {
+		cutoff := encodeTime(now.Add(-tier.Retention))
+		for {
+			var n int
+			n, err = instance.compactChunk(tier.Name, cutoff)
+			// every chunk is committed on its own and counts even if a later one fails
+			compactionDeleted.Add(float64(n), "Bolt", tier.Name)
+			total += n
+			if err != nil || n < boltCompactionChunkSize {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	compactionDuration.Observe(time.Since(start).Seconds(), "Bolt")
+
+	if err != nil {
+		log.Printf("history: compaction failed: %v", err)
+		compactionErrors.Inc("Bolt")
+		return
+	}
+
+	// the statistics of a bucket reflect committed pages only
+	instance.db.View(func(tx *bbolt.Tx) error {
+		for _, tier := range instance.tiers {
+			tierBucket := tx.Bucket([]byte(tier.Name))
+			tierBucket.ForEach(func(series, _ []byte) error {
+				stored[tier.Name] += tierBucket.Bucket(series).Stats().KeyN
+				return nil
+			})
+		}
+		return nil
+	})
+
+	for _, tier := range instance.tiers {
+		storedSamples.Set(float64(stored[tier.Name]), "Bolt", tier.Name)
+	}
+	if total > 0 {
+		log.Printf("history: compaction deleted %v samples", total)
+	}
+
+	if info, err := os.Stat(instance.db.Path()); err == nil {
+		storageSize.Set(float64(info.Size()), "Bolt")
+	}
+}
+
+// compactChunk deletes at most boltCompactionChunkSize samples older than cutoff within one transaction;
+// short transactions let the writer go routine continue in between
+func (instance *BoltStorageInstance) compactChunk(tierName string, cutoff []byte) (deleted int, err error) {
+	err = instance.db.Update(func(tx *bbolt.Tx) error {
+		tierBucket := tx.Bucket([]byte(tierName))
+
+		// collect the series first; a bucket must not be modified while iterating over it
+		series := make([][]byte, 0)
+		tierBucket.ForEach(func(name, _ []byte) error {
+			series = append(series, append([]byte(nil), name...))
+			return nil
+		})
+
+		for _, name := range series {
+			// start from the first key again after every delete; deleting while iterating using a cursor skips elements
+			c := tierBucket.Bucket(name).Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.First() {
+				if deleted >= boltCompactionChunkSize {
+					return nil
+				}
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				deleted++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+func (instance *BoltStorageInstance) Query(query Query) (series Series, err error) {
+	if err := query.Validate(); err != nil {
+		return Series{}, err
+	}
+
+	name := seriesName(query.Device.Name, query.ValueName)
+	tier := selectTier(instance.tiers, query, time.Now())
+	var samples []sample
+	var unit string
+
+	err = instance.db.View(func(tx *bbolt.Tx) error {
+		unit = string(tx.Bucket(unitsBucket).Get([]byte(name)))
+
+		// aggregated tiers are only written once a bucket is complete; fall back to finer tiers if still empty
+		for _, t := range fallbackTiers(instance.tiers, tier) {
+			samples = readSamples(tx.Bucket([]byte(t.Name)).Bucket([]byte(name)), query)
+			if len(samples) > 0 {
+				return nil
+			}
+		}
+		return ErrNoHistory
+	})
+	if err != nil {
+		return Series{}, err
+	}
+
+	series = Series{
+		DeviceName: query.Device.Name,
+		ValueName:  query.ValueName,
+		Unit:       unit,
+		Step:       query.Step.String(),
+		Points:     downsample(samples, query),
+	}
+	return
+}
+
+func readSamples(seriesBucket *bbolt.Bucket, query Query) (samples []sample) {
+	samples = make([]sample, 0)
+	if seriesBucket == nil {
+		return
+	}
+
+	c := seriesBucket.Cursor()
+	from := encodeTime(query.From)
+	to := encodeTime(query.To)
+
+	// the last sample before the range is used as initial value
+	if k, _ := c.Seek(from); k == nil {
+		if k, v := c.Last(); k != nil {
+			samples = append(samples, decodeSample(k, v))
+		}
+	} else if k, v := c.Prev(); k != nil {
+		samples = append(samples, decodeSample(k, v))
+	}
+
+	for k, v := c.Seek(from); k != nil && string(k) <= string(to); k, v = c.Next() {
+		samples = append(samples, decodeSample(k, v))
+	}
+	return
+}
+
+// this is a simple fan-in routine which copies all inputs to the input channel
+func (instance *BoltStorageInstance) Fill(input <-chan dataflow.Value) {
+	go func() {
+		for value := range input {
+			instance.inputChannel <- value
+		}
+	}()
+}
+
+func seriesName(deviceName, valueName string) string {
+	return deviceName + "/" + valueName
+}
+
+func encodeTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func encodeSample(s sample) []byte {
+	b := make([]byte, 9)
+	binary.BigEndian.PutUint64(b, math.Float64bits(s.Value))
+	if s.Stale {
+		b[8] = 1
+	}
+	return b
+}
+
+func decodeSample(k, v []byte) sample {
+	s := sample{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(k))),
+	}
+	if len(v) >= 8 {
+		s.Value = math.Float64frombits(binary.BigEndian.Uint64(v))
+	}
+	if len(v) >= 9 {
+		s.Stale = v[8] == 1
+	}
+	return s
+}
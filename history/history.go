@@ -0,0 +1,187 @@
+package history
+
+import (
+	"errors"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"time"
+)
+
+// HistoryWriter is implemented by every history backend; it is appended to a storage like any other sink
+type HistoryWriter interface {
+	dataflow.Fillable
+}
+
+// HistoryReader is implemented by every history backend which is able to serve the history api
+type HistoryReader interface {
+	Query(query Query) (Series, error)
+}
+
+// HistoryBackend is implemented by backends which can be written and read
+type HistoryBackend interface {
+	HistoryWriter
+	HistoryReader
+}
+
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+type Series struct {
+	DeviceName string
+	ValueName  string
+	Unit       string
+	Step       string
+	Points     []Point
+}
+
+type Query struct {
+	Device    *storage.Device
+	ValueName string
+	From      time.Time
+	To        time.Time
+	// 0: return the stored samples without downsampling
+	Step time.Duration
+}
+
+// a sample as it is stored; stale samples mark gaps in the time series
+type sample struct {
+	Time  time.Time
+	Value float64
+	Stale bool
+}
+
+const MaxPoints = 10000
+
+var ErrTooManyPoints = errors.New("too many points requested, increase step or reduce the time range")
+
+// ErrNoHistory is returned by Query if nothing has been stored for the requested value
+var ErrNoHistory = errors.New("no history for value")
+
+func (query Query) Validate() error {
+	if !query.From.Before(query.To) {
+		return errors.New("from must be before to")
+	}
+	if query.Step < 0 {
+		return errors.New("step must not be negative")
+	}
+	if query.Step > 0 && int64(query.To.Sub(query.From)/query.Step) > MaxPoints {
+		return ErrTooManyPoints
+	}
+	return nil
+}
+
+// timeAverage is the average of a value over time; every value is weighted by how long it was held
+type timeAverage struct {
+	sum      float64
+	duration time.Duration
+}
+
+func (average *timeAverage) add(value float64, held time.Duration) {
+	if held <= 0 {
+		return
+	}
+	average.sum += value * float64(held)
+	average.duration += held
+}
+
+// value returns false if no value has been held for any time
+func (average timeAverage) value() (float64, bool) {
+	if average.duration <= 0 {
+		return 0, false
+	}
+	return average.sum / float64(average.duration), true
+}
+
+// downsample computes the time weighted average per step; since only changes are stored, every sample
+// holds its value until the next sample and empty steps repeat the last known value until a stale sample
+// marks the end of the data
+func downsample(samples []sample, query Query) (points []Point) {
+	d := downsamplerCreate(query)
+	for _, s := range samples {
+		d.add(s)
+	}
+	return d.finish()
+}
+
+// downsampler computes the points of a query from samples added in chronological order
+// such that a backend can stream its samples instead of loading all of them into memory
+type downsampler struct {
+	query  Query
+	points []Point
+
+	// the bucket currently aggregated; the last value is held from since until the next sample
+	bucketStart time.Time
+	average     timeAverage
+	held        bool
+	value       float64
+	since       time.Time
+}
+
+func downsamplerCreate(query Query) *downsampler {
+	return &downsampler{
+		query:       query,
+		points:      make([]Point, 0),
+		bucketStart: query.From,
+		since:       query.From,
+	}
+}
+
+func (d *downsampler) add(s sample) {
+	if d.query.Step <= 0 {
+		if s.Stale || s.Time.Before(d.query.From) || s.Time.After(d.query.To) {
+			return
+		}
+		d.points = append(d.points, Point{Time: s.Time, Value: s.Value})
+		// only the last MaxPoints are returned; trim from time to time to bound the memory
+		if len(d.points) >= 2*MaxPoints {
+			d.points = append(d.points[:0], d.points[len(d.points)-MaxPoints:]...)
+		}
+		return
+	}
+
+	// the last sample before the range is used as initial value
+	if !s.Time.Before(d.query.From) {
+		d.finishBuckets(s.Time)
+		if d.held {
+			d.average.add(d.value, s.Time.Sub(d.since))
+		}
+		d.since = s.Time
+	}
+	d.held = !s.Stale
+	d.value = s.Value
+}
+
+// finishBuckets appends the points of all buckets which end at or before t
+func (d *downsampler) finishBuckets(t time.Time) {
+	for d.bucketStart.Before(d.query.To) {
+		bucketEnd := d.bucketStart.Add(d.query.Step)
+		if t.Before(bucketEnd) {
+			return
+		}
+
+		if d.held {
+			d.average.add(d.value, bucketEnd.Sub(d.since))
+		}
+		if v, ok := d.average.value(); ok {
+			d.points = append(d.points, Point{Time: d.bucketStart, Value: v})
+		}
+
+		d.average = timeAverage{}
+		d.bucketStart = bucketEnd
+		d.since = bucketEnd
+	}
+}
+
+func (d *downsampler) finish() []Point {
+	if d.query.Step <= 0 {
+		if len(d.points) > MaxPoints {
+			d.points = d.points[len(d.points)-MaxPoints:]
+		}
+		return d.points
+	}
+
+	d.finishBuckets(d.query.To.Add(d.query.Step))
+	return d.points
+}
@@ -0,0 +1,152 @@
+package history
+
+import (
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"sort"
+	"time"
+)
+
+// MemoryStorageInstance keeps the last samples of every device / value in a bounded ring buffer
+type MemoryStorageInstance struct {
+	// this represents the state of the storage instance and must only be access by the main go routine
+	capacity int
+	buffers  map[*storage.Device]map[string]*ringBuffer
+
+	// communication channels to/from the main go routine
+	inputChannel        chan dataflow.Value
+	queryRequestChannel chan *queryRequest
+}
+
+type ringBuffer struct {
+	unit    string
+	samples []sample
+	// position of the next write
+	next int
+	full bool
+}
+
+type queryResponse struct {
+	series Series
+	err    error
+}
+
+type queryRequest struct {
+	query    Query
+	response chan queryResponse
+}
+
+func MemoryStorageCreate(capacity int) (instance *MemoryStorageInstance) {
+	instance = &MemoryStorageInstance{
+		capacity:            capacity,
+		buffers:             make(map[*storage.Device]map[string]*ringBuffer),
+		inputChannel:        make(chan dataflow.Value, 32), // input channel is buffered
+		queryRequestChannel: make(chan *queryRequest),
+	}
+
+	// start main go routine
+	go instance.mainStorageRoutine()
+
+	return
+}
+
+func (instance *MemoryStorageInstance) mainStorageRoutine() {
+	for {
+		select {
+		case newValue := <-instance.inputChannel:
+			instance.handleNewValue(newValue)
+		case request := <-instance.queryRequestChannel:
+			series, err := instance.handleQuery(request.query)
+			request.response <- queryResponse{series: series, err: err}
+		}
+	}
+}
+
+func (instance *MemoryStorageInstance) handleNewValue(value dataflow.Value) {
+	if _, ok := instance.buffers[value.Device]; !ok {
+		instance.buffers[value.Device] = make(map[string]*ringBuffer)
+	}
+
+	buffer, ok := instance.buffers[value.Device][value.Name]
+	if !ok {
+		buffer = &ringBuffer{
+			samples: make([]sample, instance.capacity),
+		}
+		instance.buffers[value.Device][value.Name] = buffer
+	}
+
+	t := value.Time
+	if t.IsZero() || value.Stale {
+		// the time of a stale value is the time of its last update; the gap starts now
+		t = time.Now()
+	}
+
+	buffer.unit = value.Unit
+	buffer.push(sample{Time: t, Value: value.Value, Stale: value.Stale})
+}
+
+func (instance *MemoryStorageInstance) handleQuery(query Query) (series Series, err error) {
+	buffer, ok := instance.buffers[query.Device][query.ValueName]
+	if !ok {
+		return series, ErrNoHistory
+	}
+
+	series = Series{
+		DeviceName: query.Device.Name,
+		ValueName:  query.ValueName,
+		Unit:       buffer.unit,
+		Step:       query.Step.String(),
+		Points:     downsample(buffer.ordered(), query),
+	}
+	return
+}
+
+func (buffer *ringBuffer) push(s sample) {
+	buffer.samples[buffer.next] = s
+	buffer.next++
+	if buffer.next >= len(buffer.samples) {
+		buffer.next = 0
+		buffer.full = true
+	}
+}
+
+// ordered returns a copy of the samples, oldest first
+func (buffer *ringBuffer) ordered() (samples []sample) {
+	if !buffer.full {
+		samples = make([]sample, buffer.next)
+		copy(samples, buffer.samples[:buffer.next])
+	} else {
+		samples = make([]sample, 0, len(buffer.samples))
+		samples = append(samples, buffer.samples[buffer.next:]...)
+		samples = append(samples, buffer.samples[:buffer.next]...)
+	}
+
+	// time stamps come from the sources and may be slightly out of order
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	return
+}
+
+// this is a simple fan-in routine which copies all inputs to the input channel
+func (instance *MemoryStorageInstance) Fill(input <-chan dataflow.Value) {
+	go func() {
+		for value := range input {
+			instance.inputChannel <- value
+		}
+	}()
+}
+
+func (instance *MemoryStorageInstance) Query(query Query) (Series, error) {
+	if err := query.Validate(); err != nil {
+		return Series{}, err
+	}
+
+	response := make(chan queryResponse)
+	instance.queryRequestChannel <- &queryRequest{
+		query:    query,
+		response: response,
+	}
+	r := <-response
+	return r.series, r.err
+}
@@ -0,0 +1,41 @@
+package history
+
+import (
+	"github.com/koestler/go-ve-sensor/metrics"
+)
+
+var compactionDuration = metrics.SummaryCreate(
+	"vesensor_history_compaction_duration_seconds",
+	"Duration of the compaction job which enforces the retention of every tier.",
+	"backend",
+)
+
+var compactionDeleted = metrics.CounterVecCreate(
+	"vesensor_history_compaction_deleted_samples_total",
+	"Number of samples deleted because they are older than the retention of their tier.",
+	"backend", "tier",
+)
+
+var compactionErrors = metrics.CounterVecCreate(
+	"vesensor_history_compaction_errors_total",
+	"Number of failed compaction runs.",
+	"backend",
+)
+
+var droppedSamples = metrics.CounterVecCreate(
+	"vesensor_history_dropped_samples_total",
+	"Number of samples dropped because the storage could not keep up.",
+	"backend",
+)
+
+var storedSamples = metrics.GaugeVecCreate(
+	"vesensor_history_samples",
+	"Number of stored samples per tier after the last compaction.",
+	"backend", "tier",
+)
+
+var storageSize = metrics.GaugeVecCreate(
+	"vesensor_history_storage_size_bytes",
+	"Size of the history storage after the last compaction.",
+	"backend",
+)
@@ -0,0 +1,472 @@
+package history
+
+import (
+	"errors"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"sync"
+	"time"
+)
+
+// MongoStorageInstance writes a snapshot of the current state of every device into the RawValues collection
+// every RawValuesIntervall. Aggregated tiers store the time weighted average of the snapshots within their resolution
+// in one collection per tier. Documents are batched and kept in a bounded buffer while the database is unreachable.
+type MongoStorageInstance struct {
+	config             *config.MongoConfig
+	tiers              []config.HistoryTierConfig
+	compactionInterval time.Duration
+
+	// this represents the state of the storage instance and must only be access by the main go routine
+	state        dataflow.State
+	lastSnapshot time.Time
+	aggregates   map[string]*mongoAggregate
+	buffer       []mongoWrite
+	inFlight     []mongoWrite
+	sending      bool
+
+	// the session is connected in the background and copied by the main go routine, compaction and queries
+	sessionMutex sync.RWMutex
+	session      *mgo.Session
+	connecting   bool
+
+	// communication channels to/from the main go routine
+	inputChannel chan dataflow.Value
+	insertResult chan error
+}
+
+// one document per device and snapshot; stale values are omitted and mark a gap in the time series
+type mongoDocument struct {
+	Device string             `bson:"device"`
+	Model  string             `bson:"model"`
+	Time   time.Time          `bson:"time"`
+	Values map[string]float64 `bson:"values"`
+	Units  map[string]string  `bson:"units"`
+}
+
+type mongoWrite struct {
+	collection string
+	doc        mongoDocument
+}
+
+// the time weighted averages of all snapshots since start per device and value
+type mongoAggregate struct {
+	start  time.Time
+	values map[*storage.Device]map[string]*mongoAverage
+}
+
+type mongoAverage struct {
+	average timeAverage
+	unit    string
+}
+
+const mongoRawCollection = "RawValues"
+const mongoDialTimeout = 5 * time.Second
+
+func MongoStorageCreate(
+	mongoConfig *config.MongoConfig,
+	tiers []config.HistoryTierConfig,
+	compactionInterval time.Duration,
+) (instance *MongoStorageInstance) {
+	instance = &MongoStorageInstance{
+		config:             mongoConfig,
+		tiers:              tiers,
+		compactionInterval: compactionInterval,
+		state:              make(dataflow.State),
+		aggregates:         make(map[string]*mongoAggregate),
+		buffer:             make([]mongoWrite, 0, mongoConfig.BatchSize),
+		inputChannel:       make(chan dataflow.Value, 32), // input channel is buffered
+		insertResult:       make(chan error),
+	}
+
+	// start main go routine
+	go instance.mainStorageRoutine()
+
+	return
+}
+
+func mongoCollectionName(tier config.HistoryTierConfig) string {
+	if tier.Resolution <= 0 {
+		return mongoRawCollection
+	}
+	return "AverageValues_" + tier.Name
+}
+
+func (instance *MongoStorageInstance) mainStorageRoutine() {
+	instance.connect()
+
+	ticker := time.NewTicker(time.Duration(instance.config.RawValuesIntervall) * time.Millisecond)
+	defer ticker.Stop()
+
+	compactionTicker := time.NewTicker(instance.compactionInterval)
+	defer compactionTicker.Stop()
+
+	for {
+		select {
+		case newValue := <-instance.inputChannel:
+			instance.handleNewValue(newValue)
+		case now := <-ticker.C:
+			instance.snapshot(now)
+			instance.send()
+		case err := <-instance.insertResult:
+			instance.handleInsertResult(err)
+		case now := <-compactionTicker.C:
+			// compaction only uses its own copy of the session and must not block the main routine
+			go instance.compact(now)
+		}
+	}
+}
+
+func (instance *MongoStorageInstance) handleNewValue(value dataflow.Value) {
+	if _, ok := instance.state[value.Device]; !ok {
+		instance.state[value.Device] = make(dataflow.ValueMap)
+	}
+	instance.state[value.Device][value.Name] = value
+}
+
+// snapshot appends one document per device to the buffer and updates the aggregated tiers
+func (instance *MongoStorageInstance) snapshot(now time.Time) {
+	for _, tier := range instance.tiers {
+		if tier.Resolution <= 0 {
+			continue
+		}
+		start := now.Truncate(tier.Resolution)
+		if aggregate, ok := instance.aggregates[tier.Name]; ok && !aggregate.start.Equal(start) {
+			instance.finishAggregate(tier, aggregate)
+			delete(instance.aggregates, tier.Name)
+		}
+		if _, ok := instance.aggregates[tier.Name]; !ok {
+			instance.aggregates[tier.Name] = &mongoAggregate{
+				start:  start,
+				values: make(map[*storage.Device]map[string]*mongoAverage),
+			}
+		}
+	}
+
+	// every snapshot stands for the time since the previous one, usually RawValuesIntervall
+	held := time.Duration(instance.config.RawValuesIntervall) * time.Millisecond
+	if !instance.lastSnapshot.IsZero() && now.After(instance.lastSnapshot) {
+		held = now.Sub(instance.lastSnapshot)
+	}
+	instance.lastSnapshot = now
+
+	for device, valueMap := range instance.state {
+		doc := mongoDocument{
+			Device: device.Name,
+			Model:  device.Model,
+			Time:   now,
+			Values: make(map[string]float64, len(valueMap)),
+			Units:  make(map[string]string, len(valueMap)),
+		}
+		for name, value := range valueMap {
+			if value.Stale {
+				continue
+			}
+			doc.Values[name] = value.Value
+			doc.Units[name] = value.Unit
+		}
+		instance.buffer = append(instance.buffer, mongoWrite{collection: mongoRawCollection, doc: doc})
+
+		for _, aggregate := range instance.aggregates {
+			aggregate.add(device, doc, held)
+		}
+	}
+
+	instance.limitBuffer()
+}
+
+func (aggregate *mongoAggregate) add(device *storage.Device, doc mongoDocument, held time.Duration) {
+	if _, ok := aggregate.values[device]; !ok {
+		aggregate.values[device] = make(map[string]*mongoAverage)
+	}
+	for name, value := range doc.Values {
+		average, ok := aggregate.values[device][name]
+		if !ok {
+			average = &mongoAverage{}
+			aggregate.values[device][name] = average
+		}
+		average.average.add(value, held)
+		average.unit = doc.Units[name]
+	}
+}
+
+func (instance *MongoStorageInstance) finishAggregate(tier config.HistoryTierConfig, aggregate *mongoAggregate) {
+	for device, values := range aggregate.values {
+		doc := mongoDocument{
+			Device: device.Name,
+			Model:  device.Model,
+			Time:   aggregate.start,
+			Values: make(map[string]float64, len(values)),
+			Units:  make(map[string]string, len(values)),
+		}
+		for name, average := range values {
+			if value, ok := average.average.value(); ok {
+				doc.Values[name] = value
+				doc.Units[name] = average.unit
+			}
+		}
+		instance.buffer = append(instance.buffer, mongoWrite{collection: mongoCollectionName(tier), doc: doc})
+	}
+}
+
+// limitBuffer drops the oldest documents if more than MaxBuffer documents are buffered
+func (instance *MongoStorageInstance) limitBuffer() {
+	if overflow := len(instance.buffer) - instance.config.MaxBuffer; overflow > 0 {
+		log.Printf("history: mongo buffer full, drop %v documents", overflow)
+		instance.buffer = append(instance.buffer[:0], instance.buffer[overflow:]...)
+	}
+}
+
+// send starts inserting the next batch of documents of the same collection in a separate go routine
+// unless an insert is already in progress; this keeps the input drained while the database is unreachable
+func (instance *MongoStorageInstance) send() {
+	if instance.sending || len(instance.buffer) < 1 {
+		return
+	}
+
+	session := instance.getSession()
+	if session == nil {
+		// not connected yet, try again and keep buffering
+		instance.connect()
+		return
+	}
+
+	collection := instance.buffer[0].collection
+	n := 0
+	for n < len(instance.buffer) && n < instance.config.BatchSize && instance.buffer[n].collection == collection {
+		n++
+	}
+
+	instance.inFlight = make([]mongoWrite, n)
+	copy(instance.inFlight, instance.buffer[:n])
+	instance.buffer = append(instance.buffer[:0], instance.buffer[n:]...)
+	instance.sending = true
+
+	docs := make([]interface{}, n)
+	for i, write := range instance.inFlight {
+		docs[i] = write.doc
+	}
+
+	go func() {
+		defer session.Close()
+		instance.insertResult <- session.DB(instance.config.DatabaseName).C(collection).Insert(docs...)
+	}()
+}
+
+func (instance *MongoStorageInstance) handleInsertResult(err error) {
+	instance.sending = false
+
+	if err != nil {
+		// put the batch back in front of the buffer and retry on the next tick
+		log.Printf("history: cannot insert into mongo, %v documents buffered: %v", len(instance.buffer)+len(instance.inFlight), err)
+		instance.buffer = append(instance.inFlight, instance.buffer...)
+		instance.inFlight = nil
+		instance.limitBuffer()
+		instance.reconnect()
+		return
+	}
+
+	instance.inFlight = nil
+
+	// continue immediately if there is a backlog
+	instance.send()
+}
+
+// connect dials the database in the background unless this is already in progress
+func (instance *MongoStorageInstance) connect() {
+	instance.sessionMutex.Lock()
+	defer instance.sessionMutex.Unlock()
+	if instance.connecting || instance.session != nil {
+		return
+	}
+	instance.connecting = true
+
+	go func() {
+		session, err := mgo.DialWithTimeout("mongodb://"+instance.config.MongoHost, mongoDialTimeout)
+		if err != nil {
+			log.Printf("history: cannot connect to mongo at %v: %v", instance.config.MongoHost, err)
+		} else {
+			session.SetMode(mgo.Monotonic, true)
+			for _, tier := range instance.tiers {
+				ensureIndexAndIgnoreError(session.DB(instance.config.DatabaseName).C(mongoCollectionName(tier)), mgo.Index{
+					Key:        []string{"device", "time"},
+					Background: true,
+				})
+			}
+			log.Printf("history: connected to mongo at %v", instance.config.MongoHost)
+		}
+
+		instance.sessionMutex.Lock()
+		instance.connecting = false
+		if err == nil {
+			instance.session = session
+		}
+		instance.sessionMutex.Unlock()
+	}()
+}
+
+// reconnect discards the sockets of the current session; mgo dials again on the next operation
+func (instance *MongoStorageInstance) reconnect() {
+	instance.sessionMutex.Lock()
+	defer instance.sessionMutex.Unlock()
+	if instance.session != nil {
+		instance.session.Refresh()
+	}
+}
+
+// getSession returns a copy of the current session which must be closed by the caller or nil if not connected
+func (instance *MongoStorageInstance) getSession() *mgo.Session {
+	instance.sessionMutex.RLock()
+	defer instance.sessionMutex.RUnlock()
+	if instance.session == nil {
+		return nil
+	}
+	return instance.session.Copy()
+}
+
+func ensureIndexAndIgnoreError(collection *mgo.Collection, index mgo.Index) {
+	if err := collection.EnsureIndex(index); err != nil {
+		log.Printf("history: mongo index creation failed: %v", err)
+	}
+}
+
+// compact deletes all documents older than the retention of their tier
+func (instance *MongoStorageInstance) compact(now time.Time) {
+	session := instance.getSession()
+	if session == nil {
+		return
+	}
+	defer session.Close()
+
+	start := time.Now()
+	db := session.DB(instance.config.DatabaseName)
+	total := 0
+
+	for _, tier := range instance.tiers {
+		collection := db.C(mongoCollectionName(tier))
+
+		info, err := collection.RemoveAll(bson.M{"time": bson.M{"$lt": now.Add(-tier.Retention)}})
+		if err != nil {
+			log.Printf("history: mongo compaction of tier %v failed: %v", tier.Name, err)
+			compactionErrors.Inc("Mongo")
+			continue
+		}
+		compactionDeleted.Add(float64(info.Removed), "Mongo", tier.Name)
+		total += info.Removed
+
+		if count, err := collection.Count(); err == nil {
+			storedSamples.Set(float64(count), "Mongo", tier.Name)
+		}
+	}
+
+	compactionDuration.Observe(time.Since(start).Seconds(), "Mongo")
+
+	if total > 0 {
+		log.Printf("history: mongo compaction deleted %v documents", total)
+	}
+
+	var stats struct {
+		StorageSize float64 `bson:"storageSize"`
+	}
+	if err := db.Run(bson.D{{Name: "dbStats", Value: 1}}, &stats); err == nil {
+		storageSize.Set(stats.StorageSize, "Mongo")
+	}
+}
+
+// this is a simple fan-in routine which copies all inputs to the input channel
+func (instance *MongoStorageInstance) Fill(input <-chan dataflow.Value) {
+	go func() {
+		for value := range input {
+			instance.inputChannel <- value
+		}
+	}()
+}
+
+func (instance *MongoStorageInstance) Query(query Query) (series Series, err error) {
+	if err := query.Validate(); err != nil {
+		return Series{}, err
+	}
+
+	session := instance.getSession()
+	if session == nil {
+		return Series{}, errors.New("mongo is not connected")
+	}
+	defer session.Close()
+
+	// aggregated tiers are only written once a bucket is complete; fall back to finer tiers if still empty
+	tier := selectTier(instance.tiers, query, time.Now())
+	for _, t := range fallbackTiers(instance.tiers, tier) {
+		collection := session.DB(instance.config.DatabaseName).C(mongoCollectionName(t))
+		series, err = queryMongoCollection(collection, query)
+		if err != ErrNoHistory {
+			return
+		}
+	}
+
+	return Series{}, ErrNoHistory
+}
+
+// queryMongoCollection streams the documents of the range through the downsampler; only the requested value is fetched
+func queryMongoCollection(collection *mgo.Collection, query Query) (series Series, err error) {
+	selector := bson.M{
+		"time":                      1,
+		"values." + query.ValueName: 1,
+		"units." + query.ValueName:  1,
+	}
+
+	d := downsamplerCreate(query)
+	unit := ""
+	found := false
+	add := func(doc mongoDocument) {
+		value, ok := doc.Values[query.ValueName]
+		if ok {
+			found = true
+			unit = doc.Units[query.ValueName]
+		}
+		d.add(sample{Time: doc.Time, Value: value, Stale: !ok})
+	}
+
+	// the last snapshot before the range is used as initial value
+	var previous mongoDocument
+	err = collection.Find(bson.M{
+		"device": query.Device.Name,
+		"time":   bson.M{"$lt": query.From},
+	}).Select(selector).Sort("-time").One(&previous)
+	if err == nil {
+		add(previous)
+	} else if err != mgo.ErrNotFound {
+		return Series{}, err
+	}
+
+	iter := collection.Find(bson.M{
+		"device": query.Device.Name,
+		"time":   bson.M{"$gte": query.From, "$lte": query.To},
+	}).Select(selector).Sort("time").Iter()
+	for {
+		// decode every document into a fresh value; mgo would merge the maps otherwise
+		var doc mongoDocument
+		if !iter.Next(&doc) {
+			break
+		}
+		add(doc)
+	}
+	if err := iter.Close(); err != nil {
+		return Series{}, err
+	}
+
+	if !found {
+		return Series{}, ErrNoHistory
+	}
+
+	series = Series{
+		DeviceName: query.Device.Name,
+		ValueName:  query.ValueName,
+		Unit:       unit,
+		Step:       query.Step.String(),
+		Points:     d.finish(),
+	}
+	return
+}
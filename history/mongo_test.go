@@ -0,0 +1,137 @@
+package history
+
+import (
+	"fmt"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"gopkg.in/mgo.v2"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// these integration tests need a running mongod; set MONGO_HOST to use another host than 127.0.0.1
+func mongoTestConfig(t *testing.T) *config.MongoConfig {
+	host := os.Getenv("MONGO_HOST")
+	if len(host) < 1 {
+		host = "127.0.0.1"
+	}
+
+	session, err := mgo.DialWithTimeout("mongodb://"+host, time.Second)
+	if err != nil {
+		t.Skipf("mongo is not reachable at %v: %v", host, err)
+	}
+
+	mongoConfig := &config.MongoConfig{
+		MongoHost:          host,
+		DatabaseName:       fmt.Sprintf("go-ve-sensor-test-%d", time.Now().UnixNano()),
+		RawValuesIntervall: 50,
+		BatchSize:          16,
+		MaxBuffer:          1024,
+	}
+
+	t.Cleanup(func() {
+		session.DB(mongoConfig.DatabaseName).DropDatabase()
+		session.Close()
+	})
+
+	return mongoConfig
+}
+
+func TestMongoQueryCollection(t *testing.T) {
+	mongoConfig := mongoTestConfig(t)
+
+	session, err := mgo.DialWithTimeout("mongodb://"+mongoConfig.MongoHost, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	collection := session.DB(mongoConfig.DatabaseName).C(mongoRawCollection)
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := []mongoDocument{
+		{Device: "bmv", Time: from.Add(-time.Minute), Values: map[string]float64{"Power": 10}, Units: map[string]string{"Power": "W"}},
+		{Device: "bmv", Time: from.Add(59 * time.Minute), Values: map[string]float64{"Power": 50, "Voltage": 24}, Units: map[string]string{"Power": "W", "Voltage": "V"}},
+		{Device: "bmv", Time: from.Add(90 * time.Minute), Values: map[string]float64{}, Units: map[string]string{}},
+		{Device: "other", Time: from.Add(30 * time.Minute), Values: map[string]float64{"Power": 1000}, Units: map[string]string{"Power": "W"}},
+	}
+	for _, doc := range docs {
+		if err := collection.Insert(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	device := &storage.Device{Name: "bmv"}
+	query := Query{Device: device, ValueName: "Power", From: from, To: from.Add(2 * time.Hour), Step: time.Hour}
+
+	series, err := queryMongoCollection(collection, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.Unit != "W" {
+		t.Errorf("unexpected unit=%v", series.Unit)
+	}
+	// 10 for 59 minutes and 50 for 1 minute, then 50 for 30 minutes until the value is missing
+	expected := []Point{
+		{Time: from, Value: (10*59 + 50) / 60.0},
+		{Time: from.Add(time.Hour), Value: 50},
+	}
+	if len(series.Points) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, series.Points)
+	}
+	for i, point := range series.Points {
+		if !point.Time.Equal(expected[i].Time) || math.Abs(point.Value-expected[i].Value) > 1e-9 {
+			t.Errorf("expected %v, got %v", expected[i], point)
+		}
+	}
+
+	query.Step = 0
+	series, err = queryMongoCollection(collection, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series.Points) != 1 || series.Points[0].Value != 50 {
+		t.Errorf("unexpected raw points=%v", series.Points)
+	}
+
+	query.ValueName = "Unknown"
+	if _, err := queryMongoCollection(collection, query); err != ErrNoHistory {
+		t.Errorf("expected ErrNoHistory, got %v", err)
+	}
+}
+
+func TestMongoStorage(t *testing.T) {
+	mongoConfig := mongoTestConfig(t)
+
+	instance := MongoStorageCreate(mongoConfig, []config.HistoryTierConfig{
+		{Name: "Raw", Retention: time.Hour},
+	}, time.Hour)
+
+	device := &storage.Device{Name: "bmv"}
+	input := make(chan dataflow.Value, 1)
+	instance.Fill(input)
+	input <- dataflow.Value{Device: device, Name: "Power", Value: 42, Unit: "W", Time: time.Now()}
+
+	// wait for the connection, a snapshot and the insert
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		series, err := instance.Query(Query{
+			Device:    device,
+			ValueName: "Power",
+			From:      time.Now().Add(-time.Minute),
+			To:        time.Now(),
+		})
+		if err == nil && len(series.Points) > 0 {
+			if series.Points[0].Value != 42 || series.Unit != "W" {
+				t.Errorf("unexpected series=%+v", series)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value not stored in time, last error: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,45 @@
+package history
+
+import (
+	"github.com/koestler/go-ve-sensor/config"
+	"time"
+)
+
+// selectTier returns the coarsest tier which still has a resolution fine enough for the requested step
+// and which covers the requested range
+func selectTier(tiers []config.HistoryTierConfig, query Query, now time.Time) (selected config.HistoryTierConfig) {
+	found := false
+	for _, tier := range tiers {
+		if now.Add(-tier.Retention).After(query.From) {
+			continue
+		}
+		if !found || tier.Resolution <= query.Step {
+			selected = tier
+			found = true
+		}
+	}
+
+	if !found {
+		// nothing covers the full range; use the tier with the longest retention
+		for _, tier := range tiers {
+			if !found || tier.Retention > selected.Retention {
+				selected = tier
+				found = true
+			}
+		}
+	}
+
+	return
+}
+
+// fallbackTiers returns the given tier followed by all finer tiers, coarsest first;
+// aggregated tiers are only written once a bucket is complete and may still be empty
+func fallbackTiers(tiers []config.HistoryTierConfig, selected config.HistoryTierConfig) (fallback []config.HistoryTierConfig) {
+	fallback = []config.HistoryTierConfig{selected}
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if tiers[i].Resolution < selected.Resolution {
+			fallback = append(fallback, tiers[i])
+		}
+	}
+	return
+}
@@ -0,0 +1,237 @@
+package httpServer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"github.com/koestler/go-ve-sensor/config"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type Role int
+
+const (
+	// RolePublic routes are accessible without credentials, e.g. the frontend assets
+	RolePublic Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+var roleNames = map[string]Role{
+	"viewer":   RoleViewer,
+	"operator": RoleOperator,
+	"admin":    RoleAdmin,
+}
+
+// authenticator resolves the credentials of a request to a role
+type authenticator struct {
+	config *config.AuthConfig
+
+	// anonymous requests get this role; RolePublic: credentials are required
+	anonymousRole Role
+
+	// checking a bcrypt hash is deliberately slow; successful basic auth checks are cached
+	// by a hash of the credentials since browsers send them with every request
+	basicCacheMutex sync.Mutex
+	basicCache      map[[sha256.Size]byte]Role
+}
+
+func authenticatorCreate(authConfig *config.AuthConfig) *authenticator {
+	return &authenticator{
+		config:        authConfig,
+		anonymousRole: roleNames[authConfig.AnonymousRole],
+		basicCache:    make(map[[sha256.Size]byte]Role),
+	}
+}
+
+var errNoCredentials = errors.New("no credentials")
+
+// authenticate returns the role of the request; credentials are read from the Authorization header,
+// for websocket and event stream clients which cannot set headers, from the access_token parameter
+// which accessTokenHandler has moved into the context, or from a verified client certificate
+func (auth *authenticator) authenticate(r *http.Request) (Role, error) {
+	authorization := r.Header.Get("Authorization")
+
+	if len(authorization) < 1 {
+		if token, ok := r.Context().Value(accessTokenKey{}).(string); ok {
+			return auth.authenticateBearer(token)
+		}
+		if role, ok := auth.authenticateCert(r); ok {
+			return role, nil
+		}
+		return auth.anonymousRole, errNoCredentials
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		return auth.authenticateBasic(user, password)
+	}
+
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return auth.authenticateBearer(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return RolePublic, errors.New("unsupported authorization scheme")
+}
+
+func (auth *authenticator) authenticateBasic(user, password string) (Role, error) {
+	key := sha256.Sum256([]byte(user + "\x00" + password))
+
+	auth.basicCacheMutex.Lock()
+	role, ok := auth.basicCache[key]
+	auth.basicCacheMutex.Unlock()
+	if ok {
+		return role, nil
+	}
+
+	for _, userConfig := range auth.config.Users {
+		if userConfig.Name != user {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(userConfig.PasswordHash), []byte(password)) != nil {
+			break
+		}
+
+		role := roleNames[userConfig.Role]
+		auth.basicCacheMutex.Lock()
+		auth.basicCache[key] = role
+		auth.basicCacheMutex.Unlock()
+		return role, nil
+	}
+
+	return RolePublic, fmt.Errorf("invalid password for user=%v", user)
+}
+
+// authenticateCert maps the common name of a client certificate to a role; the tls server has verified
+// the certificate against the ClientCaFile, unverified certificates are not present in VerifiedChains
+func (auth *authenticator) authenticateCert(r *http.Request) (Role, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 || len(r.TLS.VerifiedChains[0]) < 1 {
+		return RolePublic, false
+	}
+
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, certConfig := range auth.config.Certs {
+		if certConfig.CommonName == commonName {
+			return roleNames[certConfig.Role], true
+		}
+	}
+
+	return RolePublic, false
+}
+
+func (auth *authenticator) authenticateBearer(token string) (Role, error) {
+	for _, tokenConfig := range auth.config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(tokenConfig.Token), []byte(token)) == 1 {
+			return roleNames[tokenConfig.Role], nil
+		}
+	}
+
+	if len(auth.config.JwtSecret) > 0 && strings.Count(token, ".") == 2 {
+		return auth.authenticateJwt(token)
+	}
+
+	return RolePublic, errors.New("invalid token")
+}
+
+// authenticateJwt accepts HS256 tokens signed with the configured secret; exp and nbf are checked if present
+func (auth *authenticator) authenticateJwt(tokenString string) (Role, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// only accept the one algorithm in use; anything else, e.g. none or RS256 keyed with the secret, is rejected
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method=%v", token.Header["alg"])
+		}
+		return []byte(auth.config.JwtSecret), nil
+	})
+	if err != nil {
+		return RolePublic, fmt.Errorf("invalid jwt: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return RolePublic, errors.New("invalid jwt")
+	}
+
+	roleName, _ := claims[auth.config.JwtRoleClaim].(string)
+	role, ok := roleNames[roleName]
+	if !ok {
+		return RolePublic, fmt.Errorf("jwt has unknown role=%v", roleName)
+	}
+
+	return role, nil
+}
+
+// authHandler rejects requests whose role is below the role required by the route
+// before the route's handler, and hence a websocket upgrade, is run
+type authHandler struct {
+	auth       *authenticator
+	role       Role
+	handler    http.Handler
+	jsonErrors bool
+}
+
+func (handler authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler.role == RolePublic {
+		handler.handler.ServeHTTP(w, r)
+		return
+	}
+
+	role, err := handler.auth.authenticate(r)
+	if err == errNoCredentials && role >= handler.role {
+		// the anonymous role suffices
+		err = nil
+	}
+	if err != nil {
+		if err != errNoCredentials {
+			log.Printf("httpServer: authentication failed for %v: %v", r.URL.Path, err)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="go-ve-sensor"`)
+		writeError(w, http.StatusUnauthorized, "authentication required", handler.jsonErrors)
+		return
+	}
+
+	if role < handler.role {
+		writeError(w, http.StatusForbidden, "insufficient role", handler.jsonErrors)
+		return
+	}
+
+	handler.handler.ServeHTTP(w, r)
+}
+
+type accessTokenKey struct{}
+
+// accessTokenHandler removes the access_token parameter from the request such that it is never written
+// to the access log; the token is only passed on to the authenticator for routes which accept it
+type accessTokenHandler struct {
+	handler http.Handler
+	accept  bool
+}
+
+func (handler accessTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	token := query.Get("access_token")
+	if len(token) < 1 {
+		handler.handler.ServeHTTP(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	if handler.accept {
+		ctx = context.WithValue(ctx, accessTokenKey{}, token)
+	}
+	r = r.WithContext(ctx)
+
+	query.Del("access_token")
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	r.URL = &u
+	r.RequestURI = u.RequestURI()
+
+	handler.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,124 @@
+package httpServer
+
+import (
+	"github.com/koestler/go-ve-sensor/dataflow"
+)
+
+// eventHub numbers all changes of the rounded storage, keeps the last ones for clients resuming
+// a server-sent events stream and forwards new events to all connected clients.
+// Slow clients are dropped instead of blocking the storage.
+type eventHub struct {
+	// this represents the state of the hub and must only be access by the main go routine
+	lastId  uint64
+	replay  []event
+	clients map[*eventClient]bool
+
+	// communication channels to/from the main go routine
+	input             <-chan dataflow.Value
+	registerChannel   chan *eventClient
+	unregisterChannel chan *eventClient
+}
+
+type event struct {
+	id    uint64
+	value dataflow.Value
+}
+
+type eventClient struct {
+	// set by the client: resume after this event if it is still in the replay buffer
+	resumeId uint64
+	resume   bool
+
+	// set by the hub during registration
+	resumed bool
+	replay  []event
+	// the id of the last event before the registration
+	lastId     uint64
+	registered chan struct{}
+
+	// closed by the hub when the client is unregistered or too slow
+	output chan event
+}
+
+const eventReplaySize = 1024
+const eventClientBufferSize = 256
+
+func eventHubCreate(valueStorage *dataflow.ValueStorageInstance) *eventHub {
+	hub := &eventHub{
+		replay:            make([]event, 0, eventReplaySize),
+		clients:           make(map[*eventClient]bool),
+		input:             valueStorage.Subscribe(dataflow.Filter{}),
+		registerChannel:   make(chan *eventClient),
+		unregisterChannel: make(chan *eventClient),
+	}
+
+	go hub.mainHubRoutine()
+
+	return hub
+}
+
+func (hub *eventHub) mainHubRoutine() {
+	for {
+		select {
+		case value := <-hub.input:
+			hub.handleNewValue(value)
+		case client := <-hub.registerChannel:
+			hub.handleRegister(client)
+		case client := <-hub.unregisterChannel:
+			if hub.clients[client] {
+				delete(hub.clients, client)
+				close(client.output)
+			}
+		}
+	}
+}
+
+func (hub *eventHub) handleNewValue(value dataflow.Value) {
+	hub.lastId++
+	e := event{id: hub.lastId, value: value}
+
+	if len(hub.replay) >= eventReplaySize {
+		hub.replay = append(hub.replay[:0], hub.replay[1:]...)
+	}
+	hub.replay = append(hub.replay, e)
+
+	for client := range hub.clients {
+		select {
+		case client.output <- e:
+		default:
+			// the client cannot keep up; it has to reconnect and resume
+			delete(hub.clients, client)
+			close(client.output)
+		}
+	}
+}
+
+func (hub *eventHub) handleRegister(client *eventClient) {
+	client.lastId = hub.lastId
+
+	// resuming is only possible if no event after resumeId has been dropped from the replay buffer
+	if client.resume && client.resumeId <= hub.lastId &&
+		(len(hub.replay) < 1 || hub.replay[0].id <= client.resumeId+1) {
+		client.resumed = true
+		for _, e := range hub.replay {
+			if e.id > client.resumeId {
+				client.replay = append(client.replay, e)
+			}
+		}
+	}
+
+	hub.clients[client] = true
+	close(client.registered)
+}
+
+// register returns once the client receives events; check client.resumed to know if a snapshot is needed
+func (hub *eventHub) register(client *eventClient) {
+	client.registered = make(chan struct{})
+	client.output = make(chan event, eventClientBufferSize)
+	hub.registerChannel <- client
+	<-client.registered
+}
+
+func (hub *eventHub) unregister(client *eventClient) {
+	hub.unregisterChannel <- client
+}
@@ -4,8 +4,10 @@ package httpServer
 //https://elithrar.github.io/article/custom-handlers-avoiding-globals/
 
 import (
+	"github.com/koestler/go-ve-sensor/alarm"
 	"github.com/koestler/go-ve-sensor/config"
 	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/history"
 	"github.com/koestler/go-ve-sensor/storage"
 	"log"
 	"net/http"
@@ -13,9 +15,20 @@ import (
 
 // Our application wide data containers
 type Environment struct {
-	RoundedStorage   *dataflow.ValueStorageInstance
-	Devices          []*storage.Device
-	MqttClientConfig *config.MqttClientConfig
+	RoundedStorage    *dataflow.ValueStorageInstance
+	Devices           []*storage.Device
+	MqttClientConfigs []*config.MqttClientConfig
+	AlarmEngine       *alarm.Engine
+	// nil: websocket outputs are not filtered by a deadband
+	WsDeadbandRules *dataflow.DeadbandRules
+	History         history.HistoryReader
+	// nil: authentication is disabled and all routes are public
+	AuthConfig *config.AuthConfig
+
+	// created by Run: fans out the rounded values to the server-sent events streams
+	events *eventHub
+	// created by newRouter from the api v1 routes
+	openApi openApiObject
 }
 
 // Error represents a handler error. It provides methods for a HTTP status
@@ -49,6 +62,8 @@ type HandlerHandleFunc func(e *Environment, w http.ResponseWriter, r *http.Reque
 type Handler struct {
 	Env    *Environment
 	Handle HandlerHandleFunc
+	// errors are written as an ErrorMessage object instead of plain text, used by the v1 api
+	JsonErrors bool
 }
 
 // ServeHTTP allows our Handler type to satisfy httpServer.Handler.
@@ -63,13 +78,12 @@ func (handler Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			// We can retrieve the status here and write out a specific
 			// HTTP status code.
 			log.Printf("HTTP %d - %s", e.Status(), e)
-			http.Error(w, http.StatusText(e.Status()), e.Status())
+			writeError(w, e.Status(), e.Error(), handler.JsonErrors)
 			return
 		default:
 			// Any error types we don't specifically look out for default
 			// to serving a HTTP 500
-			http.Error(w, http.StatusText(http.StatusInternalServerError),
-				http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, err.Error(), handler.JsonErrors)
 			return
 		}
 	}
@@ -2,9 +2,22 @@ package httpServer
 
 import (
 	"github.com/gorilla/websocket"
-	"net/http"
 	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
 	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// time allowed to write a message to the client
+	wsWriteTimeout = 10 * time.Second
+	// the client must answer a ping within this time, otherwise the connection is closed
+	wsPongTimeout = 60 * time.Second
+	// pings are sent with this interval; must be less than wsPongTimeout
+	wsPingInterval = wsPongTimeout * 9 / 10
+	// maximum size of a message sent by the client
+	wsMaxMessageSize = 4096
 )
 
 var upgrader = websocket.Upgrader{
@@ -16,21 +29,43 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// GET /api/v0/ws/RoundedValues?devices=12v-bmv&values=Voltage,Power
+// The current state matching the filter is sent first, followed by all changes.
+// The filter can be changed at any time by sending a subscribe message, e.g.
+// {"Type": "Subscribe", "Devices": ["12v-bmv"], "Values": ["Voltage"]}; empty lists match everything.
+// The current state matching the new filter is sent again after every subscribe message.
 func HandleWsRoundedValues(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	params := r.URL.Query()
+	filter, err := createFilter(splitParameter(params.Get("devices")), splitParameter(params.Get("values")))
+	if err != nil {
+		return StatusError{400, err}
+	}
+
 	// upgrade to websocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return nil
 	}
 
-	// setup filter
-	filter := dataflow.Filter{}
+	// subscribe to all data; the filter of the client is applied by the sink since it can change at any time
+	subscription := env.RoundedStorage.Subscribe(dataflow.Filter{})
+	dataChan := subscription
+
+	// optionally suppress insignificant changes and limit the publish rate
+	if env.WsDeadbandRules != nil {
+		deadband := dataflow.DeadbandCreate(env.WsDeadbandRules)
+		deadband.Fill(dataChan)
+		dataChan = deadband.Drain()
+	}
+
+	filterChan := make(chan dataflow.Filter)
+	sinkDone := make(chan struct{})
 
-	// subscribe to data
-	dataChan := env.RoundedStorage.Subscribe(filter)
-	sinkJson(conn, dataChan)
+	websocketClients.Inc("RoundedValues")
+	sinkJson(conn, env.RoundedStorage, subscription, dataChan, filter, filterChan, sinkDone)
+	readSubscriptions(conn, filterChan, sinkDone)
 
-	return nil;
+	return nil
 }
 
 type Message struct {
@@ -39,7 +74,14 @@ type Message struct {
 	Value      float64
 }
 
-func convertValueToMessage(value dataflow.Value) (Message) {
+// SubscribeMessage is sent by the client to change the filter of the connection
+type SubscribeMessage struct {
+	Type    string
+	Devices []string
+	Values  []string
+}
+
+func convertValueToMessage(value dataflow.Value) Message {
 	return Message{
 		DeviceName: value.Device.Name,
 		ValueName:  value.Name,
@@ -47,12 +89,189 @@ func convertValueToMessage(value dataflow.Value) (Message) {
 	}
 }
 
-func sinkJson(conn *websocket.Conn, input <-chan dataflow.Value) {
+func createFilter(deviceNames, valueNames []string) (filter dataflow.Filter, err error) {
+	if len(deviceNames) > 0 {
+		filter.Devices = make(map[*storage.Device]bool, len(deviceNames))
+		for _, name := range deviceNames {
+			device, err := storage.GetByName(name)
+			if err != nil {
+				return filter, err
+			}
+			filter.Devices[device] = true
+		}
+	}
+
+	if len(valueNames) > 0 {
+		filter.ValueNames = make(map[string]bool, len(valueNames))
+		for _, name := range valueNames {
+			filter.ValueNames[name] = true
+		}
+	}
+
+	return filter, nil
+}
+
+// setupWsReader limits the size of messages sent by the client and closes the connection
+// if the client does not answer the pings sent by the writer
+func setupWsReader(conn *websocket.Conn) {
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+}
+
+func isUnexpectedWsError(err error) bool {
+	return websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure)
+}
+
+// readWsUntilClosed discards all messages sent by the client and closes done once the connection is gone
+func readWsUntilClosed(conn *websocket.Conn, done chan<- struct{}) {
+	go func() {
+		defer close(done)
+		setupWsReader(conn)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				if isUnexpectedWsError(err) {
+					log.Printf("httpServer: websocket read failed: %v", err)
+				}
+				return
+			}
+		}
+	}()
+}
+
+func writeWsJson(conn *websocket.Conn, v interface{}) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(v)
+}
+
+func writeWsPing(conn *websocket.Conn) error {
+	return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+}
+
+// readSubscriptions reads subscribe messages and forwards the resulting filters to the sink;
+// filterChan is closed once the connection is gone. sinkDone is closed by the sink when it stops reading filterChan.
+func readSubscriptions(conn *websocket.Conn, filterChan chan<- dataflow.Filter, sinkDone <-chan struct{}) {
+	go func() {
+		defer close(filterChan)
+		setupWsReader(conn)
+		for {
+			var message SubscribeMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				if isUnexpectedWsError(err) {
+					log.Printf("httpServer: websocket read failed: %v", err)
+				}
+				return
+			}
+
+			if message.Type != "Subscribe" {
+				log.Printf("httpServer: websocket ignore message of unknown type=%v", message.Type)
+				continue
+			}
+
+			filter, err := createFilter(message.Devices, message.Values)
+			if err != nil {
+				log.Printf("httpServer: websocket ignore invalid subscription: %v", err)
+				continue
+			}
+			select {
+			case filterChan <- filter:
+			case <-sinkDone:
+				return
+			}
+		}
+	}()
+}
+
+// sinkJson writes the current state matching the filter followed by all matching changes to the connection;
+// it is the only writer of the connection. Once the connection is gone, the subscription is removed and
+// the sink ends as soon as the input is closed.
+func sinkJson(
+	conn *websocket.Conn,
+	valueStorage *dataflow.ValueStorageInstance,
+	subscription <-chan dataflow.Value,
+	input <-chan dataflow.Value,
+	filter dataflow.Filter,
+	filterChan <-chan dataflow.Filter,
+	sinkDone chan<- struct{},
+) {
 	go func() {
 		log.Printf("SinkJson started")
-		for value := range input {
-			conn.WriteJSON(convertValueToMessage(value))
+		defer log.Printf("SinkJson stoped")
+
+		pingTicker := time.NewTicker(wsPingInterval)
+		defer pingTicker.Stop()
+
+		// the state is fetched in a separate go routine since the storage blocks until the input is drained;
+		// changes received meanwhile are held back and sent after the state
+		var snapshotChan chan dataflow.State
+		var pending []dataflow.Value
+		requestSnapshot := func() {
+			snapshotChan = make(chan dataflow.State, 1)
+			pending = make([]dataflow.Value, 0)
+			go func(filter dataflow.Filter, response chan<- dataflow.State) {
+				response <- valueStorage.GetState(filter)
+			}(filter, snapshotChan)
+		}
+
+		requestSnapshot()
+
+		err := func() error {
+			for {
+				select {
+				case state := <-snapshotChan:
+					for _, valueMap := range state {
+						for _, value := range valueMap {
+							if err := writeWsJson(conn, convertValueToMessage(value)); err != nil {
+								return err
+							}
+						}
+					}
+					for _, value := range pending {
+						if err := writeWsJson(conn, convertValueToMessage(value)); err != nil {
+							return err
+						}
+					}
+					snapshotChan = nil
+					pending = nil
+				case newFilter, ok := <-filterChan:
+					if !ok {
+						// the reader has stopped: the client has gone away
+						return nil
+					}
+					filter = newFilter
+					requestSnapshot()
+				case value, ok := <-input:
+					if !ok {
+						return nil
+					}
+					if !filter.Matches(value) {
+						continue
+					}
+					if snapshotChan != nil {
+						pending = append(pending, value)
+					} else if err := writeWsJson(conn, convertValueToMessage(value)); err != nil {
+						return err
+					}
+				case <-pingTicker.C:
+					if err := writeWsPing(conn); err != nil {
+						return err
+					}
+				}
+			}
+		}()
+
+		if err != nil {
+			log.Printf("httpServer: websocket write failed: %v", err)
+		}
+
+		// teardown: closing the connection stops the reader; the input is drained until the storage has closed it
+		close(sinkDone)
+		conn.Close()
+		websocketClients.Dec("RoundedValues")
+		go valueStorage.Unsubscribe(subscription)
+		for range input {
 		}
-		log.Printf("SinkJson stoped")
 	}()
 }
@@ -0,0 +1,103 @@
+package httpServer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"log"
+	"net/http"
+	"time"
+)
+
+func HandleAlarmIndex(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.AlarmEngine == nil {
+		return StatusError{404, errors.New("alarm module not enabled")}
+	}
+
+	alarms := alarm.ConvertToMessages(env.AlarmEngine.GetActive())
+
+	writeJsonHeaders(w)
+	b, err := json.MarshalIndent(alarms, "", "    ")
+	if err != nil {
+		return StatusError{500, err}
+	}
+	w.Write(b)
+	return nil
+}
+
+func HandleAlarmAcknowledge(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.AlarmEngine == nil {
+		return StatusError{404, errors.New("alarm module not enabled")}
+	}
+
+	vars := mux.Vars(r)
+
+	if err := env.AlarmEngine.Acknowledge(vars["RuleName"], vars["DeviceId"]); err != nil {
+		return StatusError{404, err}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func HandleWsAlarms(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.AlarmEngine == nil {
+		return StatusError{404, errors.New("alarm module not enabled")}
+	}
+
+	// upgrade to websocket
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil
+	}
+
+	// subscribe to alarm events
+	alarmChan := env.AlarmEngine.Subscribe()
+
+	websocketClients.Inc("Alarms")
+
+	done := make(chan struct{})
+	readWsUntilClosed(conn, done)
+
+	go func() {
+		log.Printf("HandleWsAlarms started")
+		defer log.Printf("HandleWsAlarms stoped")
+
+		pingTicker := time.NewTicker(wsPingInterval)
+		defer pingTicker.Stop()
+
+		err := func() error {
+			for {
+				select {
+				case a, ok := <-alarmChan:
+					if !ok {
+						return nil
+					}
+					if err := writeWsJson(conn, a.ConvertToMessage()); err != nil {
+						return err
+					}
+				case <-pingTicker.C:
+					if err := writeWsPing(conn); err != nil {
+						return err
+					}
+				case <-done:
+					return nil
+				}
+			}
+		}()
+
+		if err != nil {
+			log.Printf("httpServer: websocket write failed: %v", err)
+		}
+
+		// teardown: the subscription is drained until the engine has closed it
+		conn.Close()
+		websocketClients.Dec("Alarms")
+		go env.AlarmEngine.Unsubscribe(alarmChan)
+		for range alarmChan {
+		}
+	}()
+
+	return nil
+}
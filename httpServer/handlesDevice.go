@@ -61,6 +61,10 @@ func HandleDeviceGetPicture(env *Environment, w http.ResponseWriter, r *http.Req
 		return StatusError{404, err}
 	}
 
+	return writeDevicePicture(w, device, thumb)
+}
+
+func writeDevicePicture(w http.ResponseWriter, device *storage.Device, thumb bool) Error {
 	picture, err := storage.PictureDb.GetPicture(device)
 	if err != nil {
 		return StatusError{404, err}
@@ -0,0 +1,115 @@
+package httpServer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const eventHeartbeatInterval = 15 * time.Second
+
+// GET /api/v0/Events?devices=12v-bmv&values=Voltage,Power
+// Server-sent events stream of the rounded values using the same messages and filters as the websocket.
+// A new stream starts with the current state; a reconnecting client sending Last-Event-ID (or ?lastEventId=)
+// receives the missed events instead, as long as they are still in the replay buffer.
+func HandleEvents(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.events == nil {
+		return StatusError{404, errors.New("events not available")}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return StatusError{500, errors.New("streaming not supported")}
+	}
+
+	params := r.URL.Query()
+	filter, err := createFilter(splitParameter(params.Get("devices")), splitParameter(params.Get("values")))
+	if err != nil {
+		return StatusError{400, err}
+	}
+
+	client := &eventClient{}
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if len(lastEventId) < 1 {
+		lastEventId = params.Get("lastEventId")
+	}
+	if len(lastEventId) > 0 {
+		if client.resumeId, err = strconv.ParseUint(lastEventId, 10, 64); err != nil {
+			return StatusError{400, errors.New("invalid Last-Event-ID")}
+		}
+		client.resume = true
+	}
+
+	env.events.register(client)
+	defer env.events.unregister(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: 3000\n\n"); err != nil {
+		return nil
+	}
+
+	if client.resumed {
+		for _, e := range client.replay {
+			if filter.Matches(e.value) {
+				if err := writeEvent(w, e.id, e.value); err != nil {
+					return nil
+				}
+			}
+		}
+	} else {
+		// all snapshot values carry the id of the last event before the registration
+		for _, valueMap := range env.RoundedStorage.GetState(filter) {
+			for _, value := range valueMap {
+				if err := writeEvent(w, client.lastId, value); err != nil {
+					return nil
+				}
+			}
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-client.output:
+			if !ok {
+				// dropped by the hub since the client was too slow; it reconnects and resumes
+				return nil
+			}
+			if !filter.Matches(e.value) {
+				continue
+			}
+			if err := writeEvent(w, e.id, e.value); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprintf(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, id uint64, value dataflow.Value) error {
+	b, err := json.Marshal(convertValueToMessage(value))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, b)
+	return err
+}
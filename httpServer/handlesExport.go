@@ -0,0 +1,274 @@
+package httpServer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/history"
+	"github.com/koestler/go-ve-sensor/storage"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GET /api/v0/Export?devices=12v-bmv,24v-bmv&values=Voltage,Power&from=...&to=...&step=1m&format=csv
+// devices / values: comma separated lists, default: all devices / all values currently known of a device
+// from / to: see HandleDeviceGetHistory, default: the last 24 hours
+// step: resolution of the export, 0 exports the stored samples, default: 1m
+// format: csv or ndjson (one json object per line), default: csv
+func HandleExport(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.History == nil {
+		return StatusError{404, errors.New("history module not enabled")}
+	}
+
+	request, err := parseExportRequest(env, r)
+	if err != nil {
+		return StatusError{400, err}
+	}
+
+	// the headers are only set with the first byte such that an error until then is sent as a normal error response
+	stream := &exportStream{w: w, header: make(http.Header)}
+
+	var writer exportWriter
+	switch request.format {
+	case "csv":
+		stream.header.Set("Content-Type", "text/csv; charset=UTF-8")
+		writer = newCsvExportWriter(stream)
+	case "ndjson":
+		stream.header.Set("Content-Type", "application/x-ndjson; charset=UTF-8")
+		writer = newJsonExportWriter(stream)
+	}
+	stream.header.Set("Access-Control-Allow-Origin", "*")
+	stream.header.Set("Content-Disposition", "attachment; filename=export."+request.format)
+
+	flusher, _ := w.(http.Flusher)
+
+	// query the history in chunks which stay below history.MaxPoints and stream every chunk to the client
+	for _, chunk := range request.chunks() {
+		for _, series := range request.series {
+			result, err := env.History.Query(history.Query{
+				Device:    series.device,
+				ValueName: series.valueName,
+				From:      chunk.From,
+				To:        chunk.To,
+				Step:      request.step,
+			})
+			if err == history.ErrNoHistory {
+				continue
+			} else if err != nil {
+				if !stream.started {
+					return StatusError{500, err}
+				}
+				// the status has already been sent; abort the response such that the client sees an incomplete export
+				log.Printf("httpServer: abort export: %v", err)
+				panic(http.ErrAbortHandler)
+			}
+
+			for _, point := range result.Points {
+				if err := writer.write(result, point); err != nil {
+					// client has gone away
+					return nil
+				}
+			}
+		}
+
+		if err := writer.flush(); err != nil {
+			return nil
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	return nil
+}
+
+// exportStream sets the headers and remembers that the response has started on the first write
+type exportStream struct {
+	w       http.ResponseWriter
+	header  http.Header
+	started bool
+}
+
+func (stream *exportStream) Write(p []byte) (int, error) {
+	if !stream.started {
+		stream.started = true
+		for key, values := range stream.header {
+			stream.w.Header()[key] = values
+		}
+	}
+	return stream.w.Write(p)
+}
+
+type exportRequest struct {
+	series []exportSeries
+	from   time.Time
+	to     time.Time
+	step   time.Duration
+	format string
+}
+
+type exportSeries struct {
+	device    *storage.Device
+	valueName string
+}
+
+// stored samples are exported in chunks of this duration
+const exportRawChunk = 10 * time.Minute
+
+func parseExportRequest(env *Environment, r *http.Request) (request exportRequest, err error) {
+	params := r.URL.Query()
+	now := time.Now()
+
+	request.format = params.Get("format")
+	if len(request.format) < 1 {
+		request.format = "csv"
+	}
+	if request.format != "csv" && request.format != "ndjson" {
+		return request, fmt.Errorf("invalid format: %v", request.format)
+	}
+
+	if request.to, err = parseTimeParameter(params.Get("to"), now, now); err != nil {
+		return request, fmt.Errorf("invalid to: %v", err)
+	}
+	if request.from, err = parseTimeParameter(params.Get("from"), request.to.Add(-24*time.Hour), now); err != nil {
+		return request, fmt.Errorf("invalid from: %v", err)
+	}
+	if !request.from.Before(request.to) {
+		return request, errors.New("from must be before to")
+	}
+
+	request.step = time.Minute
+	if step := params.Get("step"); len(step) > 0 {
+		if request.step, err = time.ParseDuration(step); err != nil || request.step < 0 {
+			return request, fmt.Errorf("invalid step: %v", step)
+		}
+	}
+
+	devices := env.Devices
+	if list := splitParameter(params.Get("devices")); len(list) > 0 {
+		devices = make([]*storage.Device, 0, len(list))
+		for _, name := range list {
+			device, err := storage.GetByName(name)
+			if err != nil {
+				return request, err
+			}
+			devices = append(devices, device)
+		}
+	}
+
+	valueNames := splitParameter(params.Get("values"))
+	state := env.RoundedStorage.GetState(dataflow.Filter{})
+
+	for _, device := range devices {
+		names := valueNames
+		if len(names) < 1 {
+			names = make([]string, 0, len(state[device]))
+			for name := range state[device] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			request.series = append(request.series, exportSeries{device: device, valueName: name})
+		}
+	}
+
+	return request, nil
+}
+
+// chunks splits the requested range into queries which are aligned to step and do not overlap
+func (request exportRequest) chunks() (chunks []history.Query) {
+	length := exportRawChunk
+	if request.step > 0 {
+		length = request.step * (history.MaxPoints / 2)
+	}
+
+	for from := request.from; from.Before(request.to); from = from.Add(length) {
+		to := from.Add(length)
+		if request.step <= 0 {
+			// stored samples are returned including the end of the range
+			to = to.Add(-time.Nanosecond)
+		}
+		if to.After(request.to) {
+			to = request.to
+		}
+		chunks = append(chunks, history.Query{From: from, To: to})
+	}
+	return
+}
+
+func splitParameter(param string) (list []string) {
+	for _, s := range strings.Split(param, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			list = append(list, s)
+		}
+	}
+	return
+}
+
+type exportWriter interface {
+	write(series history.Series, point history.Point) error
+	flush() error
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func newCsvExportWriter(w io.Writer) *csvExportWriter {
+	writer := &csvExportWriter{writer: csv.NewWriter(w)}
+	writer.writer.Write([]string{"Time", "Device", "Name", "Value", "Unit"})
+	return writer
+}
+
+func (writer *csvExportWriter) write(series history.Series, point history.Point) error {
+	return writer.writer.Write([]string{
+		point.Time.Format(time.RFC3339Nano),
+		series.DeviceName,
+		series.ValueName,
+		strconv.FormatFloat(point.Value, 'f', -1, 64),
+		series.Unit,
+	})
+}
+
+func (writer *csvExportWriter) flush() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
+
+type jsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+type exportLine struct {
+	Time   time.Time
+	Device string
+	Name   string
+	Value  float64
+	Unit   string
+}
+
+func newJsonExportWriter(w io.Writer) *jsonExportWriter {
+	return &jsonExportWriter{encoder: json.NewEncoder(w)}
+}
+
+func (writer *jsonExportWriter) write(series history.Series, point history.Point) error {
+	return writer.encoder.Encode(exportLine{
+		Time:   point.Time,
+		Device: series.DeviceName,
+		Name:   series.ValueName,
+		Value:  point.Value,
+		Unit:   series.Unit,
+	})
+}
+
+func (writer *jsonExportWriter) flush() error {
+	return nil
+}
@@ -0,0 +1,110 @@
+package httpServer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/koestler/go-ve-sensor/history"
+	"github.com/koestler/go-ve-sensor/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GET /api/v0/Device/{DeviceId}/History?value=Power&from=...&to=...&step=1m
+// from / to: RFC3339 time, unix timestamp in seconds or a duration relative to now (eg. -1h)
+// default: the last hour without downsampling
+func HandleDeviceGetHistory(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.History == nil {
+		return StatusError{404, errors.New("history module not enabled")}
+	}
+
+	vars := mux.Vars(r)
+
+	device, err := storage.GetByName(vars["DeviceId"])
+	if err != nil {
+		return StatusError{404, err}
+	}
+
+	query, err := parseHistoryQuery(r, r.URL.Query().Get("value"))
+	if err != nil {
+		return StatusError{400, err}
+	}
+	query.Device = device
+
+	series, err := env.History.Query(query)
+	if err != nil {
+		return historyQueryError(err)
+	}
+
+	writeJsonHeaders(w)
+	b, err := json.MarshalIndent(series, "", "    ")
+	if err != nil {
+		return StatusError{500, err}
+	}
+	w.Write(b)
+	return nil
+}
+
+// historyQueryError maps the errors of History.Query to a http status; backend failures are server errors
+func historyQueryError(err error) Error {
+	switch err {
+	case history.ErrNoHistory:
+		return StatusError{404, err}
+	case history.ErrTooManyPoints:
+		return StatusError{400, err}
+	default:
+		return StatusError{500, err}
+	}
+}
+
+func parseHistoryQuery(r *http.Request, valueName string) (query history.Query, err error) {
+	params := r.URL.Query()
+	now := time.Now()
+
+	query.ValueName = valueName
+	if len(query.ValueName) < 1 {
+		return query, errors.New("parameter value missing")
+	}
+
+	if query.To, err = parseTimeParameter(params.Get("to"), now, now); err != nil {
+		return query, fmt.Errorf("invalid to: %v", err)
+	}
+
+	if query.From, err = parseTimeParameter(params.Get("from"), query.To.Add(-time.Hour), now); err != nil {
+		return query, fmt.Errorf("invalid from: %v", err)
+	}
+
+	if step := params.Get("step"); len(step) > 0 {
+		if query.Step, err = time.ParseDuration(step); err != nil {
+			return query, fmt.Errorf("invalid step: %v", err)
+		}
+	}
+
+	if err = query.Validate(); err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+func parseTimeParameter(param string, defaultTime time.Time, now time.Time) (time.Time, error) {
+	if len(param) < 1 {
+		return defaultTime, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, param); err == nil {
+		return t, nil
+	}
+
+	if unix, err := strconv.ParseInt(param, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+
+	if d, err := time.ParseDuration(param); err == nil {
+		return now.Add(d), nil
+	}
+
+	return time.Time{}, errors.New("expected RFC3339 time, unix timestamp or duration")
+}
@@ -0,0 +1,52 @@
+package httpServer
+
+import (
+	"bytes"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/metrics"
+	"net/http"
+	"sort"
+)
+
+var websocketClients = metrics.GaugeVecCreate(
+	"vesensor_httpserver_websocket_clients",
+	"Number of connected websocket clients.",
+	"endpoint",
+)
+
+var valueLabelNames = []string{"device", "model", "value", "unit"}
+
+// HandleMetrics exposes all current values and the process internal metrics in the prometheus text format
+func HandleMetrics(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	buffer := new(bytes.Buffer)
+
+	writeValueMetrics(buffer, env.RoundedStorage.GetState(dataflow.Filter{}))
+	metrics.WriteText(buffer)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buffer.Bytes())
+	return nil
+}
+
+// writeValueMetrics writes one gauge per device and value; stale values are omitted
+func writeValueMetrics(buffer *bytes.Buffer, state dataflow.State) {
+	lines := make([]string, 0)
+	values := make(map[string]float64)
+
+	for device, valueMap := range state {
+		for name, value := range valueMap {
+			if value.Stale {
+				continue
+			}
+			labels := metrics.FormatLabels(valueLabelNames, []string{device.Name, device.Model, name, value.Unit})
+			lines = append(lines, labels)
+			values[labels] = value.Value
+		}
+	}
+	sort.Strings(lines)
+
+	metrics.WriteHeader(buffer, "vesensor_value", "Current rounded value of a device.", "gauge")
+	for _, labels := range lines {
+		metrics.WriteSample(buffer, "vesensor_value", labels, values[labels])
+	}
+}
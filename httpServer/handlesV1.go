@@ -0,0 +1,310 @@
+package httpServer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// resources of the v1 api; unlike v0 they use camelCase names and are always wrapped in an object
+
+type V1Device struct {
+	Name           string      `json:"name"`
+	Model          string      `json:"model"`
+	FrontendConfig interface{} `json:"frontendConfig"`
+}
+
+type V1DeviceList struct {
+	Items []V1Device `json:"items"`
+}
+
+type V1Value struct {
+	Device        string    `json:"device"`
+	Name          string    `json:"name"`
+	Value         float64   `json:"value"`
+	Unit          string    `json:"unit"`
+	RoundDecimals int       `json:"roundDecimals"`
+	Time          time.Time `json:"time"`
+	Stale         bool      `json:"stale"`
+	Restored      bool      `json:"restored"`
+}
+
+type V1ValueList struct {
+	Items []V1Value `json:"items"`
+}
+
+type V1Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+type V1History struct {
+	Device string    `json:"device"`
+	Value  string    `json:"value"`
+	Unit   string    `json:"unit"`
+	Step   string    `json:"step"`
+	Points []V1Point `json:"points"`
+}
+
+type V1Picture struct {
+	Device   string    `json:"device"`
+	Created  time.Time `json:"created"`
+	ThumbUrl string    `json:"thumbUrl"`
+	RawUrl   string    `json:"rawUrl"`
+}
+
+type V1Alarm struct {
+	Rule    string      `json:"rule"`
+	Device  string      `json:"device"`
+	Value   string      `json:"value"`
+	State   alarm.State `json:"state"`
+	Current float64     `json:"current"`
+	Unit    string      `json:"unit"`
+	Message string      `json:"message"`
+	Raised  time.Time   `json:"raised"`
+	Updated time.Time   `json:"updated"`
+}
+
+type V1AlarmList struct {
+	Items []V1Alarm `json:"items"`
+}
+
+func convertDeviceToV1(device *storage.Device) V1Device {
+	return V1Device{
+		Name:           device.Name,
+		Model:          device.Model,
+		FrontendConfig: device.FrontendConfig,
+	}
+}
+
+func convertValueToV1(value dataflow.Value) V1Value {
+	return V1Value{
+		Device:        value.Device.Name,
+		Name:          value.Name,
+		Value:         value.Value,
+		Unit:          value.Unit,
+		RoundDecimals: value.RoundDecimals,
+		Time:          value.Time,
+		Stale:         value.Stale,
+		Restored:      value.Restored,
+	}
+}
+
+func convertAlarmToV1(a alarm.Alarm) V1Alarm {
+	return V1Alarm{
+		Rule:    a.Rule.Name,
+		Device:  a.Device.Name,
+		Value:   a.ValueName,
+		State:   a.State,
+		Current: a.Value,
+		Unit:    a.Unit,
+		Message: a.Message,
+		Raised:  a.Raised,
+		Updated: a.Updated,
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) Error {
+	writeJsonHeaders(w)
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return StatusError{500, err}
+	}
+	w.Write(b)
+	return nil
+}
+
+func getV1Device(r *http.Request) (*storage.Device, Error) {
+	device, err := storage.GetByName(mux.Vars(r)["device"])
+	if err != nil {
+		return nil, StatusError{404, err}
+	}
+	return device, nil
+}
+
+// getV1Values returns the values of the device sorted by name
+func getV1Values(env *Environment, device *storage.Device) []V1Value {
+	valueMap := env.RoundedStorage.GetMap(dataflow.Filter{Devices: map[*storage.Device]bool{device: true}})
+
+	values := make([]V1Value, 0, len(valueMap))
+	for _, value := range valueMap {
+		values = append(values, convertValueToV1(value))
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+	return values
+}
+
+// GET /api/v1/devices
+func HandleV1DeviceIndex(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	devices := storage.GetAll()
+
+	list := V1DeviceList{Items: make([]V1Device, len(devices))}
+	for i, device := range devices {
+		list.Items[i] = convertDeviceToV1(device)
+	}
+
+	return writeJson(w, list)
+}
+
+// GET /api/v1/devices/{device}
+func HandleV1Device(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	device, err := getV1Device(r)
+	if err != nil {
+		return err
+	}
+
+	return writeJson(w, convertDeviceToV1(device))
+}
+
+// GET /api/v1/devices/{device}/values
+func HandleV1DeviceValues(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	device, err := getV1Device(r)
+	if err != nil {
+		return err
+	}
+
+	return writeJson(w, V1ValueList{Items: getV1Values(env, device)})
+}
+
+// GET /api/v1/devices/{device}/values/{value}
+func HandleV1DeviceValue(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	device, err := getV1Device(r)
+	if err != nil {
+		return err
+	}
+
+	valueName := mux.Vars(r)["value"]
+	valueMap := env.RoundedStorage.GetMap(dataflow.Filter{
+		Devices:    map[*storage.Device]bool{device: true},
+		ValueNames: map[string]bool{valueName: true},
+	})
+
+	value, ok := valueMap[valueName]
+	if !ok {
+		return StatusError{404, errors.New("value not found: " + valueName)}
+	}
+
+	return writeJson(w, convertValueToV1(value))
+}
+
+// GET /api/v1/devices/{device}/values/{value}/history?from=-1h&to=now&step=1m
+func HandleV1DeviceValueHistory(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.History == nil {
+		return StatusError{404, errors.New("history module not enabled")}
+	}
+
+	device, e := getV1Device(r)
+	if e != nil {
+		return e
+	}
+
+	query, err := parseHistoryQuery(r, mux.Vars(r)["value"])
+	if err != nil {
+		return StatusError{400, err}
+	}
+	query.Device = device
+
+	series, err := env.History.Query(query)
+	if err != nil {
+		return historyQueryError(err)
+	}
+
+	response := V1History{
+		Device: series.DeviceName,
+		Value:  series.ValueName,
+		Unit:   series.Unit,
+		Step:   series.Step,
+		Points: make([]V1Point, len(series.Points)),
+	}
+	for i, point := range series.Points {
+		response.Points[i] = V1Point{Time: point.Time, Value: point.Value}
+	}
+
+	return writeJson(w, response)
+}
+
+// GET /api/v1/devices/{device}/picture
+func HandleV1DevicePicture(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	device, e := getV1Device(r)
+	if e != nil {
+		return e
+	}
+
+	picture, err := storage.PictureDb.GetPicture(device)
+	if err != nil {
+		return StatusError{404, err}
+	}
+
+	base := "/api/v1/devices/" + device.Name + "/picture/"
+	return writeJson(w, V1Picture{
+		Device:   device.Name,
+		Created:  picture.Created,
+		ThumbUrl: base + "thumb",
+		RawUrl:   base + "raw",
+	})
+}
+
+// GET /api/v1/devices/{device}/picture/thumb
+func HandleV1DevicePictureThumb(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	device, err := getV1Device(r)
+	if err != nil {
+		return err
+	}
+	return writeDevicePicture(w, device, true)
+}
+
+// GET /api/v1/devices/{device}/picture/raw
+func HandleV1DevicePictureRaw(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	device, err := getV1Device(r)
+	if err != nil {
+		return err
+	}
+	return writeDevicePicture(w, device, false)
+}
+
+// GET /api/v1/alarms
+func HandleV1AlarmIndex(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.AlarmEngine == nil {
+		return StatusError{404, errors.New("alarm module not enabled")}
+	}
+
+	alarms := env.AlarmEngine.GetActive()
+	list := V1AlarmList{Items: make([]V1Alarm, len(alarms))}
+	for i, a := range alarms {
+		list.Items[i] = convertAlarmToV1(a)
+	}
+
+	return writeJson(w, list)
+}
+
+// POST /api/v1/alarms/{rule}/{device}/acknowledge
+func HandleV1AlarmAcknowledge(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	if env.AlarmEngine == nil {
+		return StatusError{404, errors.New("alarm module not enabled")}
+	}
+
+	vars := mux.Vars(r)
+	if err := env.AlarmEngine.Acknowledge(vars["rule"], vars["device"]); err != nil {
+		return StatusError{404, err}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// GET /api/v1/openapi.json
+func HandleV1OpenApi(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	return writeJson(w, env.openApi)
+}
+
+func HandleV1NotFound(env *Environment, w http.ResponseWriter, r *http.Request) Error {
+	return StatusError{404, errors.New("api method not found, see /api/v1/openapi.json")}
+}
@@ -2,6 +2,7 @@ package httpServer
 
 import (
 	"errors"
+	"fmt"
 	"github.com/koestler/go-ve-sensor/config"
 	"github.com/koestler/go-ve-sensor/mqttClient"
 	"github.com/koestler/go-ve-sensor/vedevices"
@@ -31,11 +32,37 @@ type hassSensor struct {
 }
 
 
+// GET /api/v0/Hass/MqttSensors?client=cloud
+// client: the name of the [MqttClient.<name>] section whose topics are used;
+// default: the first [MqttClient] or [MqttClient.<name>] section of the config file
 func HandleHassMqttSensorsYaml(env *Environment, w http.ResponseWriter, r *http.Request) Error {
-	if env.MqttClientConfig == nil {
+	if len(env.MqttClientConfigs) < 1 {
 		return StatusError{404, errors.New("mqtt module not enabled")}
 	}
 
+	mqttClientConfig := env.MqttClientConfigs[0]
+	if name := r.URL.Query().Get("client"); len(name) > 0 {
+		mqttClientConfig = nil
+		for _, cfg := range env.MqttClientConfigs {
+			if cfg.Name == name {
+				mqttClientConfig = cfg
+				break
+			}
+		}
+		if mqttClientConfig == nil {
+			return StatusError{404, fmt.Errorf("unknown mqtt client=%v", name)}
+		}
+	}
+
+	// the value is extracted from the realtime messages in their configured format
+	valueTemplate := mqttClient.GetHassRealtimeTemplate(mqttClientConfig)
+	if len(valueTemplate) < 1 {
+		return StatusError{404, fmt.Errorf(
+			"home assistant cannot extract the value of realtime messages with RealtimeFormat=%v",
+			mqttClientConfig.RealtimeFormat,
+		)}
+	}
+
 	configs := make([]hassSensor, 0)
 	for _, device := range env.Devices {
 		registers := vedevices.RegisterFactoryByProduct(device.DeviceId);
@@ -46,7 +73,8 @@ func HandleHassMqttSensorsYaml(env *Environment, w http.ResponseWriter, r *http.
 		for valueName, register := range registers {
 			configs = append(configs,
 				registerToHassSensor(
-					env.MqttClientConfig,
+					mqttClientConfig,
+					valueTemplate,
 					device.Name,
 					device.Model,
 					valueName,
@@ -68,6 +96,7 @@ func HandleHassMqttSensorsYaml(env *Environment, w http.ResponseWriter, r *http.
 
 func registerToHassSensor(
 	mqttClientConfig *config.MqttClientConfig,
+	valueTemplate string,
 	deviceName string,
 	deviceModel string,
 	valueName string,
@@ -84,7 +113,7 @@ func registerToHassSensor(
 			unit,
 		),
 		AvailabilityTopic:   mqttClient.GetAvailableTopic(mqttClientConfig),
-		ValueTemplate:       "{{ value_json.Value }}",
+		ValueTemplate:       valueTemplate,
 		UnitOfMeasurement:   unit,
 		PayloadAvailable:    "Online",
 		PayloadNotAvailable: "Offline",
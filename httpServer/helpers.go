@@ -1,6 +1,9 @@
 package httpServer
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+)
 
 func writeJsonHeaders(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -16,3 +19,31 @@ func writeJpegHeaders(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "image/jpeg")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 }
+
+// ErrorMessage is returned by the v1 api for all errors
+type ErrorMessage struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// writeError writes the status text as plain text, or an ErrorMessage including the message if jsonErrors is set;
+// the message of internal errors is never sent to the client
+func writeError(w http.ResponseWriter, status int, message string, jsonErrors bool) {
+	if !jsonErrors {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if status >= 500 {
+		message = http.StatusText(status)
+	}
+
+	writeJsonHeaders(w)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorMessage{Error: ErrorDetail{Status: status, Message: message}})
+}
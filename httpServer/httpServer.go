@@ -1,21 +1,60 @@
 package httpServer
 
 import (
+	"crypto/tls"
+	"github.com/koestler/go-ve-sensor/config"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 )
 
-func Run(bind string, port int, logFilePath string, env *Environment) {
-	go func() {
-		router := newRouter(getLogger(logFilePath), env)
-		address := bind + ":" + strconv.Itoa(port)
+func Run(httpServerConfig *config.HttpServerConfig, env *Environment) {
+	if env.RoundedStorage != nil {
+		env.events = eventHubCreate(env.RoundedStorage)
+	}
+
+	router := newRouter(getLogger(httpServerConfig.LogFile), env)
+	address := net.JoinHostPort(httpServerConfig.Bind, strconv.Itoa(httpServerConfig.Port))
+
+	if !httpServerConfig.Tls() {
+		go func() {
+			log.Printf("httpServer: listening on %v", address)
+			log.Fatal(router, http.ListenAndServe(address, router))
+		}()
+		return
+	}
+
+	tlsConfig, err := createTlsConfig(httpServerConfig)
+	if err != nil {
+		log.Fatalf("httpServer: cannot setup tls: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:      address,
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+	if !httpServerConfig.Http2 {
+		// a non-nil map disables the automatic HTTP/2 support
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
 
-		log.Printf("httpServer: listening on %v", address)
-		log.Fatal(router, http.ListenAndServe(address, router))
+	go func() {
+		log.Printf("httpServer: listening on %v using tls, Http2=%v", address, httpServerConfig.Http2)
+		// the certificate is provided by tlsConfig.GetCertificate
+		log.Fatal(server.ListenAndServeTLS("", ""))
 	}()
+
+	if httpServerConfig.RedirectPort != 0 {
+		redirectAddress := net.JoinHostPort(httpServerConfig.Bind, strconv.Itoa(httpServerConfig.RedirectPort))
+		go func() {
+			log.Printf("httpServer: redirect http on %v to https", redirectAddress)
+			log.Fatal(http.ListenAndServe(redirectAddress, redirectHandler{port: httpServerConfig.Port}))
+		}()
+	}
 }
 
 func getLogger(logFilePath string) (writer io.Writer) {
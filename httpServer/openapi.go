@@ -0,0 +1,165 @@
+package httpServer
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// the OpenAPI 3 document is generated from the api routes by newRouter;
+// schemas are derived from the response types by reflection
+
+var pathParameterDescriptions = map[string]string{
+	"device": "name of the device as configured",
+	"value":  "name of the value, e.g. Voltage",
+	"rule":   "name of the alarm rule as configured",
+}
+
+var pathParameterRegexp = regexp.MustCompile(`\{([a-zA-Z]+)\}`)
+
+type openApiObject map[string]interface{}
+
+func createOpenApiDocument(env *Environment, routes ApiRoutes) openApiObject {
+	schemas := openApiObject{}
+	openApiSchema(reflect.TypeOf(ErrorMessage{}), schemas)
+	errorResponse := openApiObject{
+		"description": "error",
+		"content": openApiObject{
+			"application/json": openApiObject{"schema": openApiObject{"$ref": "#/components/schemas/ErrorMessage"}},
+		},
+	}
+
+	paths := openApiObject{}
+	for _, route := range routes {
+		if len(route.Summary) < 1 {
+			continue
+		}
+
+		parameters := make([]openApiObject, 0)
+		for _, match := range pathParameterRegexp.FindAllStringSubmatch(route.Pattern, -1) {
+			parameters = append(parameters, openApiObject{
+				"name":        match[1],
+				"in":          "path",
+				"required":    true,
+				"description": pathParameterDescriptions[match[1]],
+				"schema":      openApiObject{"type": "string"},
+			})
+		}
+		for _, parameter := range route.Parameters {
+			parameters = append(parameters, openApiObject{
+				"name":        parameter.Name,
+				"in":          "query",
+				"required":    parameter.Required,
+				"description": parameter.Description,
+				"schema":      openApiObject{"type": "string"},
+			})
+		}
+
+		responses := openApiObject{"default": errorResponse}
+		if route.Response == nil {
+			responses["204"] = openApiObject{"description": "success"}
+		} else {
+			contentType := route.ContentType
+			schema := openApiObject{"type": "string", "format": "binary"}
+			if len(contentType) < 1 {
+				contentType = "application/json"
+				schema = openApiSchema(reflect.TypeOf(route.Response), schemas)
+			}
+			responses["200"] = openApiObject{
+				"description": "success",
+				"content":     openApiObject{contentType: openApiObject{"schema": schema}},
+			}
+		}
+
+		operation := openApiObject{
+			"operationId": route.Name,
+			"summary":     route.Summary,
+			"parameters":  parameters,
+			"responses":   responses,
+		}
+		if env.AuthConfig != nil && route.Role != RolePublic {
+			operation["security"] = []openApiObject{{"basic": []string{}}, {"bearer": []string{}}}
+		}
+
+		path, ok := paths[route.Pattern].(openApiObject)
+		if !ok {
+			path = openApiObject{}
+			paths[route.Pattern] = path
+		}
+		path[strings.ToLower(route.Method)] = operation
+	}
+
+	return openApiObject{
+		"openapi": "3.0.3",
+		"info": openApiObject{
+			"title":   "go-ve-sensor",
+			"version": "1",
+		},
+		"servers": []openApiObject{{"url": "/"}},
+		"paths":   paths,
+		"components": openApiObject{
+			"schemas": schemas,
+			"securitySchemes": openApiObject{
+				"basic":  openApiObject{"type": "http", "scheme": "basic"},
+				"bearer": openApiObject{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// openApiSchema returns the schema of the given type; named structs are added to schemas and referenced
+func openApiSchema(t reflect.Type, schemas openApiObject) openApiObject {
+	if t == timeType {
+		return openApiObject{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return openApiSchema(t.Elem(), schemas)
+	case reflect.Bool:
+		return openApiObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openApiObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return openApiObject{"type": "number"}
+	case reflect.String:
+		return openApiObject{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return openApiObject{"type": "array", "items": openApiSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return openApiObject{"type": "object", "additionalProperties": openApiSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := t.Name()
+		ref := openApiObject{"$ref": "#/components/schemas/" + name}
+		if _, ok := schemas[name]; ok {
+			return ref
+		}
+		// reserve the name before descending such that recursive types terminate
+		schemas[name] = nil
+
+		properties := openApiObject{}
+		required := make([]string, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+			if jsonName == "-" || field.PkgPath != "" {
+				continue
+			}
+			if len(jsonName) < 1 {
+				jsonName = field.Name
+			}
+			properties[jsonName] = openApiSchema(field.Type, schemas)
+			required = append(required, jsonName)
+		}
+
+		schemas[name] = openApiObject{"type": "object", "properties": properties, "required": required}
+		return ref
+	default:
+		// e.g. interface{}: any value
+		return openApiObject{}
+	}
+}
@@ -11,6 +11,8 @@ type WsRoute struct {
 	Name        string
 	Pattern     string
 	HandlerFunc HandlerHandleFunc
+	// minimal role needed to access the route; only enforced if authentication is configured
+	Role Role
 }
 
 type HttpRoute struct {
@@ -18,18 +20,57 @@ type HttpRoute struct {
 	Method      string
 	Pattern     string
 	HandlerFunc HandlerHandleFunc
+	// minimal role needed to access the route; only enforced if authentication is configured
+	Role Role
+}
+
+// ApiRoute is a route of the versioned api which is also described in the OpenAPI document
+type ApiRoute struct {
+	HttpRoute
+	// routes without a summary are not documented
+	Summary string
+	// query parameters; path parameters are taken from the pattern
+	Parameters []ApiParameter
+	// zero value of the response body used to generate its schema; nil: no content
+	Response interface{}
+	// empty: application/json
+	ContentType string
+}
+
+type ApiParameter struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// tokenParameterRoutes are used by websocket and event stream clients which cannot set an Authorization header;
+// besides the websocket routes, only they accept the access_token parameter
+var tokenParameterRoutes = map[string]bool{
+	"Events":                       true,
+	"DeviceRoundedValuesWebSocket": true,
+	"AlarmsWebSocket":              true,
 }
 
 type WsRoutes []WsRoute
 type HttpRoutes []HttpRoute
+type ApiRoutes []ApiRoute
 
 func newRouter(logger io.Writer, env *Environment) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
+	var auth *authenticator
+	if env.AuthConfig != nil {
+		auth = authenticatorCreate(env.AuthConfig)
+	}
+
 	// setup websocket routes
 	for _, route := range wsRoutes {
 		var handler http.Handler
 		handler = Handler{Env: env, Handle: route.HandlerFunc}
+		if auth != nil {
+			// checked before the upgrade to a websocket connection
+			handler = authHandler{auth: auth, role: route.Role, handler: handler}
+		}
 
 		if logger != nil {
 
@@ -37,6 +78,7 @@ func newRouter(logger io.Writer, env *Environment) *mux.Router {
 		if logger != nil {
 			handler = apachelog.CombinedLog.Wrap(handler, logger)
 		}
+		handler = accessTokenHandler{handler: handler, accept: true}
 
 		router.Path(route.Pattern).
 			Name(route.Name).
@@ -44,13 +86,36 @@ func newRouter(logger io.Writer, env *Environment) *mux.Router {
 
 	}
 
+	// setup api v1 routes; they must be added before the catch all routes of httpRoutes
+	env.openApi = createOpenApiDocument(env, apiV1Routes)
+	for _, route := range apiV1Routes {
+		var handler http.Handler
+		handler = Handler{Env: env, Handle: route.HandlerFunc, JsonErrors: true}
+		if auth != nil {
+			handler = authHandler{auth: auth, role: route.Role, handler: handler, jsonErrors: true}
+		}
+		if logger != nil {
+			handler = apachelog.CombinedLog.Wrap(handler, logger)
+		}
+		handler = accessTokenHandler{handler: handler, accept: tokenParameterRoutes[route.Name]}
+
+		router.Methods(route.Method).
+			Path(route.Pattern).
+			Name(route.Name).
+			Handler(handler)
+	}
+
 	// setup normal http routes
 	for _, route := range httpRoutes {
 		var handler http.Handler
 		handler = Handler{Env: env, Handle: route.HandlerFunc}
+		if auth != nil {
+			handler = authHandler{auth: auth, role: route.Role, handler: handler}
+		}
 		if logger != nil {
 			handler = apachelog.CombinedLog.Wrap(handler, logger)
 		}
+		handler = accessTokenHandler{handler: handler, accept: tokenParameterRoutes[route.Name]}
 
 		router.Methods(route.Method).
 			Path(route.Pattern).
@@ -5,6 +5,7 @@ var wsRoutes = WsRoutes{
 		"ws-test",
 		"/ws/v0/RoundedValues",
 		HandleWsRoundedValues,
+		RoleViewer,
 	},
 }
 
@@ -14,59 +15,118 @@ var httpRoutes = HttpRoutes{
 		"GET",
 		"/api/v0/Devices",
 		HandleDeviceIndex,
+		RoleViewer,
 	},
 	HttpRoute{
 		"FrontendConfig",
 		"GET",
 		"/api/v0/FrontendConfig",
 		HandleFrontendConfig,
+		RoleViewer,
 	},
 	HttpRoute{
 		"RoundedValues",
 		"GET",
 		"/api/v0/Device/{DeviceId:[a-zA-Z0-9\\-]{1,32}}/RoundedValues",
 		HandleDeviceGetRoundedValues,
+		RoleViewer,
+	},
+	HttpRoute{
+		"DeviceHistory",
+		"GET",
+		"/api/v0/Device/{DeviceId:[a-zA-Z0-9\\-]{1,32}}/History",
+		HandleDeviceGetHistory,
+		RoleViewer,
+	},
+	HttpRoute{
+		"Export",
+		"GET",
+		"/api/v0/Export",
+		HandleExport,
+		RoleViewer,
+	},
+	HttpRoute{
+		"Events",
+		"GET",
+		"/api/v0/Events",
+		HandleEvents,
+		RoleViewer,
 	},
 	HttpRoute{
 		"DevicePictureThumb",
 		"GET",
 		"/api/v0/Device/{DeviceId:[a-zA-Z0-9\\-]{1,32}}/Picture/Thumb",
 		HandleDeviceGetPictureThumb,
+		RoleViewer,
 	},
 	HttpRoute{
 		"DevicePictureRaw",
 		"GET",
 		"/api/v0/Device/{DeviceId:[a-zA-Z0-9\\-]{1,32}}/Picture/Raw",
 		HandleDeviceGetPictureRaw,
+		RoleViewer,
 	},
 	HttpRoute{
 		"HassMqttSensorsYaml",
 		"GET",
 		"/api/v0/Hass/MqttSensors",
 		HandleHassMqttSensorsYaml,
+		RoleViewer,
 	},
 	HttpRoute{
 		"DeviceRoundedValuesWebSocket",
 		"GET",
 		"/api/v0/ws/RoundedValues",
 		HandleWsRoundedValues,
+		RoleViewer,
+	},
+	HttpRoute{
+		"AlarmIndex",
+		"GET",
+		"/api/v0/Alarms",
+		HandleAlarmIndex,
+		RoleViewer,
+	},
+	HttpRoute{
+		"AlarmAcknowledge",
+		"POST",
+		"/api/v0/Alarm/{RuleName:[a-zA-Z0-9\\-]{1,32}}/{DeviceId:[a-zA-Z0-9\\-]{1,32}}/Acknowledge",
+		HandleAlarmAcknowledge,
+		RoleOperator,
+	},
+	HttpRoute{
+		"AlarmsWebSocket",
+		"GET",
+		"/api/v0/ws/Alarms",
+		HandleWsAlarms,
+		RoleViewer,
 	},
 	HttpRoute{
 		"ApiIndex",
 		"GET",
 		"/api{Path:.*}",
 		HandleApiNotFound,
+		RoleViewer,
+	},
+	HttpRoute{
+		"Metrics",
+		"GET",
+		"/metrics",
+		HandleMetrics,
+		RoleViewer,
 	},
 	HttpRoute{
 		"AssetsIndex",
 		"GET",
 		"/",
 		HandleAssetsGet,
+		RolePublic,
 	},
 	HttpRoute{
 		"Assets",
 		"GET",
 		"/{Path:.+}",
 		HandleAssetsGet,
+		RolePublic,
 	},
 }
@@ -0,0 +1,70 @@
+package httpServer
+
+var historyParameters = []ApiParameter{
+	{"from", "start of the range: RFC3339 time, unix timestamp or duration relative to now (e.g. -1h); default: to - 1h", false},
+	{"to", "end of the range, same format as from; default: now", false},
+	{"step", "average over steps of this duration (e.g. 1m); default: raw samples", false},
+}
+
+var apiV1Routes = ApiRoutes{
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1OpenApi", "GET", "/api/v1/openapi.json", HandleV1OpenApi, RolePublic},
+		Summary:   "OpenAPI description of this api",
+		Response:  map[string]interface{}{},
+	},
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1DeviceIndex", "GET", "/api/v1/devices", HandleV1DeviceIndex, RoleViewer},
+		Summary:   "List all devices",
+		Response:  V1DeviceList{},
+	},
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1Device", "GET", "/api/v1/devices/{device}", HandleV1Device, RoleViewer},
+		Summary:   "Get a device",
+		Response:  V1Device{},
+	},
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1DeviceValues", "GET", "/api/v1/devices/{device}/values", HandleV1DeviceValues, RoleViewer},
+		Summary:   "List the current values of a device",
+		Response:  V1ValueList{},
+	},
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1DeviceValue", "GET", "/api/v1/devices/{device}/values/{value}", HandleV1DeviceValue, RoleViewer},
+		Summary:   "Get the current value of a device",
+		Response:  V1Value{},
+	},
+	ApiRoute{
+		HttpRoute:  HttpRoute{"V1DeviceValueHistory", "GET", "/api/v1/devices/{device}/values/{value}/history", HandleV1DeviceValueHistory, RoleViewer},
+		Summary:    "Get the history of a value",
+		Parameters: historyParameters,
+		Response:   V1History{},
+	},
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1DevicePicture", "GET", "/api/v1/devices/{device}/picture", HandleV1DevicePicture, RoleViewer},
+		Summary:   "Get the metadata of the latest picture of a camera",
+		Response:  V1Picture{},
+	},
+	ApiRoute{
+		HttpRoute:   HttpRoute{"V1DevicePictureThumb", "GET", "/api/v1/devices/{device}/picture/thumb", HandleV1DevicePictureThumb, RoleViewer},
+		Summary:     "Get the latest picture of a camera as thumbnail",
+		Response:    []byte{},
+		ContentType: "image/jpeg",
+	},
+	ApiRoute{
+		HttpRoute:   HttpRoute{"V1DevicePictureRaw", "GET", "/api/v1/devices/{device}/picture/raw", HandleV1DevicePictureRaw, RoleViewer},
+		Summary:     "Get the latest picture of a camera in full resolution",
+		Response:    []byte{},
+		ContentType: "image/jpeg",
+	},
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1AlarmIndex", "GET", "/api/v1/alarms", HandleV1AlarmIndex, RoleViewer},
+		Summary:   "List the active alarms",
+		Response:  V1AlarmList{},
+	},
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1AlarmAcknowledge", "POST", "/api/v1/alarms/{rule}/{device}/acknowledge", HandleV1AlarmAcknowledge, RoleOperator},
+		Summary:   "Acknowledge an active alarm",
+	},
+	ApiRoute{
+		HttpRoute: HttpRoute{"V1NotFound", "GET", "/api/v1{Path:.*}", HandleV1NotFound, RolePublic},
+	},
+}
@@ -0,0 +1,169 @@
+package httpServer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/config"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certificate files are checked for changes with this interval
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the current certificate and loads it again on SIGHUP or when the files change,
+// e.g. after a renewal by certbot, without restarting the server
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func certReloaderCreate(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	go reloader.mainReloadRoutine()
+
+	return reloader, nil
+}
+
+func (reloader *certReloader) mainReloadRoutine() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	check := time.NewTicker(certCheckInterval)
+	defer check.Stop()
+
+	for {
+		select {
+		case <-hup:
+			log.Printf("httpServer: SIGHUP received, reload certificate")
+		case <-check.C:
+			if !reloader.changed() {
+				continue
+			}
+			log.Printf("httpServer: certificate files changed, reload certificate")
+		}
+
+		// keep serving the old certificate if the new one is invalid, e.g. only half written
+		if err := reloader.load(); err != nil {
+			log.Printf("httpServer: cannot reload certificate: %v", err)
+		}
+	}
+}
+
+func (reloader *certReloader) filesModTime() (modTime time.Time) {
+	for _, file := range []string{reloader.certFile, reloader.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return
+}
+
+func (reloader *certReloader) changed() bool {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return !reloader.filesModTime().Equal(reloader.modTime)
+}
+
+func (reloader *certReloader) load() error {
+	modTime := reloader.filesModTime()
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		// remember the failed files such that they are not loaded again every check
+		reloader.mutex.Lock()
+		reloader.modTime = modTime
+		reloader.mutex.Unlock()
+		return err
+	}
+
+	reloader.mutex.Lock()
+	reloader.cert = &cert
+	reloader.modTime = modTime
+	reloader.mutex.Unlock()
+
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		log.Printf("httpServer: certificate loaded, Subject=%v, NotAfter=%v", leaf.Subject, leaf.NotAfter)
+	}
+
+	return nil
+}
+
+func (reloader *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.cert, nil
+}
+
+func createTlsConfig(httpServerConfig *config.HttpServerConfig) (*tls.Config, error) {
+	reloader, err := certReloaderCreate(httpServerConfig.CertFile, httpServerConfig.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if len(httpServerConfig.ClientCaFile) > 0 {
+		b, err := ioutil.ReadFile(httpServerConfig.ClientCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ClientCaFile: %v", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("ClientCaFile contains no certificates")
+		}
+
+		if httpServerConfig.ClientCertRequired {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// redirectHandler sends plain http requests to the same path on the https port
+type redirectHandler struct {
+	port int
+}
+
+func (handler redirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		// no port in the host header
+		host = r.Host
+	}
+
+	if handler.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(handler.port))
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
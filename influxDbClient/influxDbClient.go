@@ -0,0 +1,284 @@
+package influxDbClient
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/lineProtocol"
+	"github.com/koestler/go-ve-sensor/storage"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type InfluxDbClient struct {
+	config     *config.InfluxDbConfig
+	writeUrl   string
+	httpClient *http.Client
+
+	// this represents the state of the client and must only be access by the main go routine
+	averages map[*storage.Device]map[string]*average
+	buffer   []string
+	inFlight []string
+	sending  bool
+	// set after a failed write; batches are then only retried on the flush interval
+	failing bool
+
+	// communication channels to/from the main go routine
+	input       <-chan dataflow.Value
+	writeResult chan writeResult
+}
+
+type writeResult struct {
+	err error
+	// false if the server rejected the data itself; retrying would fail again
+	retry bool
+}
+
+// average is the time weighted average of a value within the current interval
+type average struct {
+	sum      float64
+	duration time.Duration
+
+	// the last value is held from since until the next value; not held while stale
+	held  bool
+	value float64
+	since time.Time
+}
+
+// holdUntil adds the held value to the average up to the given time
+func (avg *average) holdUntil(t time.Time) {
+	if !t.After(avg.since) {
+		return
+	}
+	if avg.held {
+		avg.sum += avg.value * float64(t.Sub(avg.since))
+		avg.duration += t.Sub(avg.since)
+	}
+	avg.since = t
+}
+
+func Run(config *config.InfluxDbConfig, valueStorage *dataflow.ValueStorageInstance) (client *InfluxDbClient) {
+	client = &InfluxDbClient{
+		config:   config,
+		writeUrl: getWriteUrl(config),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		averages:    make(map[*storage.Device]map[string]*average),
+		buffer:      make([]string, 0, config.BatchSize),
+		input:       valueStorage.Subscribe(dataflow.Filter{}),
+		writeResult: make(chan writeResult),
+	}
+
+	go client.mainRoutine()
+
+	return
+}
+
+func getWriteUrl(config *config.InfluxDbConfig) string {
+	params := url.Values{}
+	params.Set("precision", "ms")
+
+	if config.Version == 2 {
+		params.Set("org", config.Org)
+		params.Set("bucket", config.Bucket)
+		return config.Url + "/api/v2/write?" + params.Encode()
+	}
+
+	params.Set("db", config.Database)
+	if len(config.RetentionPolicy) > 0 {
+		params.Set("rp", config.RetentionPolicy)
+	}
+	return config.Url + "/write?" + params.Encode()
+}
+
+func (client *InfluxDbClient) mainRoutine() {
+	flushTicker := time.NewTicker(client.config.FlushInterval)
+	defer flushTicker.Stop()
+
+	// a nil channel blocks forever -> averaging is disabled
+	var averageTick <-chan time.Time
+	if client.config.AverageInterval > 0 {
+		averageTicker := time.NewTicker(client.config.AverageInterval)
+		defer averageTicker.Stop()
+		averageTick = averageTicker.C
+	}
+
+	for {
+		select {
+		case value := <-client.input:
+			client.handleNewValue(value, time.Now())
+			if !client.failing && len(client.buffer) >= client.config.BatchSize {
+				client.send()
+			}
+		case now := <-averageTick:
+			client.handleAverageTick(now)
+		case <-flushTicker.C:
+			client.send()
+		case result := <-client.writeResult:
+			client.handleWriteResult(result)
+		}
+	}
+}
+
+func (client *InfluxDbClient) handleNewValue(value dataflow.Value, now time.Time) {
+	if client.config.AverageInterval <= 0 {
+		// stale values are not written; this results in a gap in the time series
+		if value.Stale {
+			return
+		}
+		client.appendLine(lineProtocol.Point{
+			Measurement: value.Device.Model,
+			Device:      value.Device.Name,
+			Fields:      map[string]float64{value.Name: value.Value},
+			Time:        value.Time,
+		})
+		return
+	}
+
+	if _, ok := client.averages[value.Device]; !ok {
+		client.averages[value.Device] = make(map[string]*average)
+	}
+	avg, ok := client.averages[value.Device][value.Name]
+	if !ok {
+		avg = &average{since: now}
+		client.averages[value.Device][value.Name] = avg
+	}
+
+	// every value is weighted by how long it was held; a stale value stops holding the last one
+	avg.holdUntil(now)
+	avg.held = !value.Stale
+	avg.value = value.Value
+}
+
+// handleAverageTick writes one point per device containing the time weighted average of every value within the interval;
+// values which did not change are held and therefore written again
+func (client *InfluxDbClient) handleAverageTick(now time.Time) {
+	for device, values := range client.averages {
+		p := lineProtocol.Point{
+			Measurement: device.Model,
+			Device:      device.Name,
+			Fields:      make(map[string]float64, len(values)),
+			Time:        now,
+		}
+		for name, avg := range values {
+			avg.holdUntil(now)
+			if avg.duration > 0 {
+				p.Fields[name] = avg.sum / float64(avg.duration)
+			}
+			avg.sum = 0
+			avg.duration = 0
+
+			if !avg.held {
+				delete(values, name)
+			}
+		}
+		if len(values) < 1 {
+			delete(client.averages, device)
+		}
+		client.appendLine(p)
+	}
+}
+
+// appendLine buffers the point using millisecond precision which matches the precision parameter of the write url
+func (client *InfluxDbClient) appendLine(p lineProtocol.Point) {
+	line := p.Line(time.Millisecond)
+	if len(line) < 1 {
+		return
+	}
+	client.buffer = append(client.buffer, line)
+	client.limitBuffer()
+}
+
+// limitBuffer drops the oldest lines if more than MaxBuffer lines are buffered
+func (client *InfluxDbClient) limitBuffer() {
+	if overflow := len(client.buffer) - client.config.MaxBuffer; overflow > 0 {
+		log.Printf("influxDbClient: buffer full, drop %v points", overflow)
+		client.buffer = append(client.buffer[:0], client.buffer[overflow:]...)
+	}
+}
+
+// send starts writing the next batch in a separate go routine unless a write is already in progress;
+// this keeps the subscription drained while the server is slow or unreachable
+func (client *InfluxDbClient) send() {
+	if client.sending || len(client.buffer) < 1 {
+		return
+	}
+
+	n := client.config.BatchSize
+	if n > len(client.buffer) {
+		n = len(client.buffer)
+	}
+
+	client.inFlight = make([]string, n)
+	copy(client.inFlight, client.buffer[:n])
+	client.buffer = append(client.buffer[:0], client.buffer[n:]...)
+	client.sending = true
+
+	body := strings.Join(client.inFlight, "\n")
+	go func() {
+		client.writeResult <- client.write(body)
+	}()
+}
+
+func (client *InfluxDbClient) handleWriteResult(result writeResult) {
+	client.sending = false
+
+	if result.err != nil && !result.retry {
+		log.Printf("influxDbClient: drop %v points rejected by the server: %v", len(client.inFlight), result.err)
+	} else if result.err != nil {
+		// put the batch back in front of the buffer and retry on the next flush
+		log.Printf("influxDbClient: write of %v points failed, retry later: %v", len(client.inFlight), result.err)
+		client.buffer = append(client.inFlight, client.buffer...)
+		client.inFlight = nil
+		client.limitBuffer()
+		client.failing = true
+		return
+	}
+
+	client.inFlight = nil
+	client.failing = false
+
+	// continue immediately if there is a backlog
+	if len(client.buffer) >= client.config.BatchSize {
+		client.send()
+	}
+}
+
+func (client *InfluxDbClient) write(body string) writeResult {
+	request, err := http.NewRequest("POST", client.writeUrl, bytes.NewBufferString(body))
+	if err != nil {
+		return writeResult{err: err}
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if client.config.Version == 2 {
+		if len(client.config.Token) > 0 {
+			request.Header.Set("Authorization", "Token "+client.config.Token)
+		}
+	} else if len(client.config.User) > 0 {
+		request.SetBasicAuth(client.config.User, client.config.Password)
+	}
+
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return writeResult{err: err, retry: true}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return writeResult{
+			err: fmt.Errorf("influxdb returned status=%v: %s", response.Status, strings.TrimSpace(string(message))),
+			// 400: malformed or partially written data, 413: too large; everything else may be temporary
+			retry: response.StatusCode != http.StatusBadRequest && response.StatusCode != http.StatusRequestEntityTooLarge,
+		}
+	}
+	return writeResult{}
+}
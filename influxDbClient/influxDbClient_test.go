@@ -0,0 +1,238 @@
+package influxDbClient
+
+import (
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubServer records all requests and answers with the given status codes; the last one is repeated
+type stubServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	statuses []int
+}
+
+func stubServerCreate(t *testing.T, statuses ...int) *stubServer {
+	stub := &stubServer{statuses: statuses}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		stub.mutex.Lock()
+		stub.requests = append(stub.requests, r)
+		stub.bodies = append(stub.bodies, string(body))
+		status := http.StatusNoContent
+		if len(stub.statuses) > 0 {
+			status = stub.statuses[0]
+			if len(stub.statuses) > 1 {
+				stub.statuses = stub.statuses[1:]
+			}
+		}
+		stub.mutex.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// waitFor waits until at least n requests have been received
+func (stub *stubServer) waitFor(t *testing.T, n int) (requests []*http.Request, bodies []string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stub.mutex.Lock()
+		requests, bodies = stub.requests, stub.bodies
+		stub.mutex.Unlock()
+
+		if len(requests) >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v requests, got %v", n, len(requests))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testConfig(url string) *config.InfluxDbConfig {
+	return &config.InfluxDbConfig{
+		Url:           url,
+		Version:       1,
+		Database:      "go-ve-sensor",
+		Storage:       "Raw",
+		FlushInterval: time.Hour,
+		BatchSize:     1,
+		MaxBuffer:     100,
+	}
+}
+
+var testDevice = &storage.Device{Name: "24v-bmv", Model: "BMV702"}
+
+// runClient starts a client and returns the channel feeding its value storage
+func runClient(cfg *config.InfluxDbConfig) chan<- dataflow.Value {
+	valueStorage := dataflow.ValueStorageCreate(time.Hour)
+	Run(cfg, valueStorage)
+
+	input := make(chan dataflow.Value)
+	valueStorage.Fill(input)
+	return input
+}
+
+func testValue(value float64) dataflow.Value {
+	return dataflow.Value{
+		Device: testDevice,
+		Name:   "MainVoltage",
+		Value:  value,
+		Unit:   "V",
+		Time:   time.Unix(1577934245, 0),
+	}
+}
+
+func TestWriteV1(t *testing.T) {
+	stub := stubServerCreate(t)
+	cfg := testConfig(stub.URL)
+	cfg.RetentionPolicy = "autogen"
+	cfg.User = "user"
+	cfg.Password = "secret"
+
+	input := runClient(cfg)
+	input <- testValue(24.5)
+
+	requests, bodies := stub.waitFor(t, 1)
+	if requests[0].URL.Path != "/write" {
+		t.Errorf("unexpected path=%v", requests[0].URL.Path)
+	}
+	params := requests[0].URL.Query()
+	if params.Get("db") != "go-ve-sensor" || params.Get("rp") != "autogen" || params.Get("precision") != "ms" {
+		t.Errorf("unexpected params=%v", params)
+	}
+	if user, password, ok := requests[0].BasicAuth(); !ok || user != "user" || password != "secret" {
+		t.Errorf("unexpected basic auth user=%v password=%v", user, password)
+	}
+	if expected := "BMV702,device=24v-bmv MainVoltage=24.5 1577934245000"; bodies[0] != expected {
+		t.Errorf("expected body=%v, got %v", expected, bodies[0])
+	}
+}
+
+func TestWriteV2(t *testing.T) {
+	stub := stubServerCreate(t)
+	cfg := testConfig(stub.URL)
+	cfg.Version = 2
+	cfg.Org = "home"
+	cfg.Bucket = "solar"
+	cfg.Token = "api-token"
+
+	input := runClient(cfg)
+	input <- testValue(24.5)
+
+	requests, _ := stub.waitFor(t, 1)
+	if requests[0].URL.Path != "/api/v2/write" {
+		t.Errorf("unexpected path=%v", requests[0].URL.Path)
+	}
+	params := requests[0].URL.Query()
+	if params.Get("org") != "home" || params.Get("bucket") != "solar" || params.Get("precision") != "ms" {
+		t.Errorf("unexpected params=%v", params)
+	}
+	if auth := requests[0].Header.Get("Authorization"); auth != "Token api-token" {
+		t.Errorf("unexpected Authorization=%v", auth)
+	}
+}
+
+func TestBatching(t *testing.T) {
+	stub := stubServerCreate(t)
+	cfg := testConfig(stub.URL)
+	cfg.BatchSize = 2
+
+	input := runClient(cfg)
+	for i := 0; i < 5; i++ {
+		input <- testValue(float64(i))
+	}
+
+	stub.waitFor(t, 2)
+	// the last value stays buffered until the next flush
+	time.Sleep(50 * time.Millisecond)
+	_, bodies := stub.waitFor(t, 2)
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %v", len(bodies))
+	}
+	for _, body := range bodies {
+		if lines := strings.Split(body, "\n"); len(lines) != 2 {
+			t.Errorf("expected 2 lines per batch, got %v", lines)
+		}
+	}
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	stub := stubServerCreate(t, http.StatusServiceUnavailable, http.StatusNoContent)
+	cfg := testConfig(stub.URL)
+	cfg.FlushInterval = 20 * time.Millisecond
+
+	input := runClient(cfg)
+	input <- testValue(24.5)
+
+	_, bodies := stub.waitFor(t, 2)
+	if bodies[0] != bodies[1] {
+		t.Errorf("expected the same batch to be retried, got %v and %v", bodies[0], bodies[1])
+	}
+}
+
+func TestDropOnRejection(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge} {
+		stub := stubServerCreate(t, status)
+		cfg := testConfig(stub.URL)
+		cfg.FlushInterval = 20 * time.Millisecond
+
+		input := runClient(cfg)
+		input <- testValue(1)
+		stub.waitFor(t, 1)
+		input <- testValue(2)
+
+		_, bodies := stub.waitFor(t, 2)
+		if strings.Contains(bodies[1], "MainVoltage=1 ") {
+			t.Errorf("status=%v: expected the rejected batch to be dropped, got %v", status, bodies[1])
+		}
+	}
+}
+
+func TestTimeWeightedAverage(t *testing.T) {
+	cfg := testConfig("")
+	cfg.AverageInterval = time.Hour
+	client := &InfluxDbClient{
+		config:   cfg,
+		averages: make(map[*storage.Device]map[string]*average),
+	}
+
+	start := time.Unix(1577934000, 0)
+	client.handleNewValue(testValue(10), start)
+	client.handleNewValue(testValue(50), start.Add(59*time.Minute))
+	client.handleAverageTick(start.Add(time.Hour))
+	// the value is held during the whole next interval
+	client.handleAverageTick(start.Add(2 * time.Hour))
+	// a stale value ends the time series
+	stale := testValue(50)
+	stale.Stale = true
+	client.handleNewValue(stale, start.Add(2*time.Hour))
+	client.handleAverageTick(start.Add(3 * time.Hour))
+
+	expected := []string{
+		"BMV702,device=24v-bmv MainVoltage=10.666666666666666 1577937600000",
+		"BMV702,device=24v-bmv MainVoltage=50 1577941200000",
+	}
+	if len(client.buffer) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, client.buffer)
+	}
+	for i := range expected {
+		if client.buffer[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], client.buffer[i])
+		}
+	}
+}
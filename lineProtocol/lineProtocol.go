@@ -0,0 +1,59 @@
+package lineProtocol
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is a single line of the influxdb line protocol: one measurement per device model,
+// the device name and the optional unit as tags and one field per value
+type Point struct {
+	Measurement string
+	Device      string
+	Unit        string
+	Fields      map[string]float64
+	Time        time.Time
+}
+
+var measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// Line formats the point with the given timestamp precision, e.g. time.Millisecond or time.Nanosecond;
+// returns an empty string if the point has no fields
+func (p Point) Line(precision time.Duration) string {
+	if len(p.Fields) < 1 {
+		return ""
+	}
+
+	// sort fields to get a deterministic output
+	keys := make([]string, 0, len(p.Fields))
+	for key := range p.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+	b.WriteString(",device=")
+	b.WriteString(tagEscaper.Replace(p.Device))
+	if len(p.Unit) > 0 {
+		b.WriteString(",unit=")
+		b.WriteString(tagEscaper.Replace(p.Unit))
+	}
+	for i, key := range keys {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(tagEscaper.Replace(key))
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatFloat(p.Fields[key], 'f', -1, 64))
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(p.Time.UnixNano()/int64(precision), 10))
+
+	return b.String()
+}
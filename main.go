@@ -2,11 +2,15 @@ package main
 
 import (
 	"github.com/jessevdk/go-flags"
+	"github.com/koestler/go-ve-sensor/alarm"
 	"github.com/koestler/go-ve-sensor/config"
 	"github.com/koestler/go-ve-sensor/dataflow"
 	"github.com/koestler/go-ve-sensor/ftpServer"
+	"github.com/koestler/go-ve-sensor/history"
 	"github.com/koestler/go-ve-sensor/httpServer"
+	"github.com/koestler/go-ve-sensor/influxDbClient"
 	"github.com/koestler/go-ve-sensor/mqttClient"
+	"github.com/koestler/go-ve-sensor/notification"
 	"github.com/koestler/go-ve-sensor/storage"
 	"github.com/koestler/go-ve-sensor/vedevices"
 	"log"
@@ -25,17 +29,30 @@ var cmdOptions CmdOptions
 
 var rawStorage, roundedStorage *dataflow.ValueStorageInstance
 
-var mqttClientConfig *config.MqttClientConfig
+var integrator *dataflow.Integrator
+
+var stateSnapshot *dataflow.StateSnapshot
+
+var alarmEngine *alarm.Engine
+
+var historyReader history.HistoryReader
+
+var mqttClientConfigs []*config.MqttClientConfig
 
 func main() {
 	log.Print("main: start go-ve-sensor...")
 
 	setupConfig()
 	setupStorageAndDataFlow()
+	setupHistory()
+	setupAlarmEngine()
+	setupNotifications()
 	setupBmvDevices()
 	setupCameraDevices()
+	setupStateSnapshot()
 	setupFtpServer()
 	setupMqttClient()
+	setupInfluxDbClient()
 	setupHttpServer()
 
 	log.Print("main: start completed; run until kill signal is received")
@@ -45,6 +62,20 @@ func main() {
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
 	<-gracefulStop
+
+	shutdown()
+}
+
+// shutdown persists all state which would otherwise be lost since the last periodic save
+func shutdown() {
+	log.Print("main: shutdown")
+
+	if stateSnapshot != nil {
+		stateSnapshot.Save()
+	}
+	if integrator != nil {
+		integrator.Save()
+	}
 }
 
 func setupConfig() {
@@ -70,19 +101,107 @@ func setupStorageAndDataFlow() {
 	// 1. sources:
 	// those are appended by separate routines
 
+	dataflowConfig := config.GetDataflowConfig()
+
 	// 2. storage for raw values
-	rawStorage = dataflow.ValueStorageCreate()
+	// this is where values which are not updated anymore are marked as stale
+	rawStorage = dataflow.ValueStorageCreate(dataflowConfig.StaleTimeout)
 
 	// 3. rounder
 	rounder := dataflow.RounderCreate()
 
 	// 4. storage for rounded values
-	roundedStorage = dataflow.ValueStorageCreate()
+	// stale events are received from the raw storage
+	roundedStorage = dataflow.ValueStorageCreate(0)
 
 	// chain those
 	rawStorage.Append(rounder)
 	rounder.Append(roundedStorage)
 
+	// 5. optional integrator computing energy counters; its output is rounded and stored like any other value
+	if integratorConfig, err := config.GetIntegratorConfig(); err == nil {
+		log.Printf("main: setup integrator, values=%v, stateFile=%v", integratorConfig.Values, integratorConfig.StateFile)
+
+		filter := dataflow.Filter{ValueNames: make(map[string]bool)}
+		for _, valueName := range integratorConfig.Values {
+			filter.ValueNames[valueName] = true
+		}
+
+		integrator = dataflow.IntegratorCreate(integratorConfig.StateFile, integratorConfig.SaveInterval)
+		integrator.Fill(rawStorage.Subscribe(filter))
+		integrator.Append(rounder)
+	} else {
+		log.Printf("main: skip integrator, err=%v", err)
+	}
+
+}
+
+func setupHistory() {
+	historyConfig, err := config.GetHistoryConfig()
+	if err != nil {
+		log.Printf("main: skip history, err=%v", err)
+		return
+	}
+
+	var backend history.HistoryBackend
+
+	switch historyConfig.Backend {
+	case "Memory":
+		log.Printf("main: setup memory history, Capacity=%v", historyConfig.Capacity)
+		backend = history.MemoryStorageCreate(historyConfig.Capacity)
+	case "Bolt":
+		log.Printf("main: setup bolt history, File=%v, Tiers=%v", historyConfig.File, historyConfig.Tiers)
+		boltStorage, err := history.BoltStorageCreate(historyConfig.File, historyConfig.Tiers, historyConfig.CompactionInterval)
+		if err != nil {
+			log.Printf("main: skip history, cannot open database: %v", err)
+			return
+		}
+		backend = boltStorage
+	case "Mongo":
+		mongoConfig, err := config.GetMongoConfig()
+		if err != nil {
+			log.Printf("main: skip history, err=%v", err)
+			return
+		}
+		log.Printf(
+			"main: setup mongo history, MongoHost=%v, DatabaseName=%v, Tiers=%v",
+			mongoConfig.MongoHost, mongoConfig.DatabaseName, historyConfig.Tiers,
+		)
+		backend = history.MongoStorageCreate(mongoConfig, historyConfig.Tiers, historyConfig.CompactionInterval)
+	}
+
+	roundedStorage.Append(backend)
+	historyReader = backend
+}
+
+func setupAlarmEngine() {
+	rules := config.GetAlarmRuleConfigs()
+	if len(rules) < 1 {
+		log.Printf("main: skip alarm engine, no rules configured")
+		return
+	}
+
+	log.Printf("main: setup alarm engine, rules=%v", len(rules))
+
+	// evaluate rules based on the rounded values
+	alarmEngine = alarm.EngineCreate(rules)
+	roundedStorage.Append(alarmEngine)
+}
+
+func setupNotifications() {
+	if alarmEngine == nil {
+		log.Printf("main: skip notifications, alarm engine not enabled")
+		return
+	}
+
+	configs := config.GetNotificationConfigs()
+	if len(configs) < 1 {
+		log.Printf("main: skip notifications, no sinks configured")
+		return
+	}
+
+	log.Printf("main: setup notifications, sinks=%v", len(configs))
+	notification.Run(configs, alarmEngine)
 }
 
 func setupBmvDevices() {
@@ -130,6 +249,19 @@ func setupCameraDevices() {
 	}
 }
 
+func setupStateSnapshot() {
+	dataflowConfig := config.GetDataflowConfig()
+	if len(dataflowConfig.SnapshotFile) < 1 {
+		log.Printf("main: skip state snapshot, no SnapshotFile configured")
+		return
+	}
+
+	log.Printf("main: setup state snapshot, SnapshotFile=%v", dataflowConfig.SnapshotFile)
+
+	// devices must be registered before the snapshot can be restored
+	stateSnapshot = dataflow.StateSnapshotCreate(rawStorage, dataflowConfig.SnapshotFile, dataflowConfig.SnapshotInterval)
+}
+
 func setupFtpServer() {
 	ftpServerConfig, err := config.GetFtpServerConfig()
 	if err == nil {
@@ -144,31 +276,99 @@ func setupFtpServer() {
 }
 
 func setupMqttClient() {
-	var err error
-	mqttClientConfig, err = config.GetMqttClientConfig()
-	if err == nil {
+	configs, err := config.GetMqttClientConfigs()
+	if err != nil {
+		log.Printf("main: skip mqtt client, err=%v", err)
+		return
+	}
+
+	// the http server generates the home assistant sensors of the client selected by the request
+	mqttClientConfigs = configs
+
+	for _, cfg := range mqttClientConfigs {
 		log.Printf(
-			"main: start mqtt client, broker=%v, clientId=%v",
-			mqttClientConfig.Broker, mqttClientConfig.ClientId,
+			"main: start mqtt client, name=%v, broker=%v, clientId=%v",
+			cfg.Name, cfg.Broker, cfg.ClientId,
 		)
-		mqttClient.Run(mqttClientConfig, roundedStorage)
+		mqttClient.Run(cfg, roundedStorage, alarmEngine, getDeadbandRules())
+	}
+}
+
+// getDeadbandRules maps the [Deadband] sections to the rules used by the mqtt and websocket deadbands
+func getDeadbandRules() *dataflow.DeadbandRules {
+	deadbandConfig := config.GetDeadbandConfig()
+
+	convert := func(rule config.DeadbandRuleConfig) dataflow.DeadbandRule {
+		return dataflow.DeadbandRule{
+			Absolute:    rule.Absolute,
+			Percent:     rule.Percent,
+			MinInterval: rule.MinInterval,
+			MaxInterval: rule.MaxInterval,
+		}
+	}
+
+	rules := &dataflow.DeadbandRules{
+		Default: convert(deadbandConfig.Default),
+		Rules:   make(map[string]dataflow.DeadbandRule, len(deadbandConfig.Rules)),
+	}
+	for valueName, rule := range deadbandConfig.Rules {
+		rules.Rules[valueName] = convert(rule)
+	}
+	return rules
+}
+
+func setupInfluxDbClient() {
+	influxDbConfig, err := config.GetInfluxDbConfig()
+	if err != nil {
+		log.Printf("main: skip influxDb client, err=%v", err)
+		return
+	}
+
+	log.Printf(
+		"main: start influxDb client, Url=%v, Version=%v, Storage=%v",
+		influxDbConfig.Url, influxDbConfig.Version, influxDbConfig.Storage,
+	)
+
+	if influxDbConfig.Storage == "Raw" {
+		influxDbClient.Run(influxDbConfig, rawStorage)
 	} else {
-		log.Printf("main: skip mqtt client, err=%v", err)
+		influxDbClient.Run(influxDbConfig, roundedStorage)
 	}
 }
 
 func setupHttpServer() {
 	httpServerConfig, err := config.GetHttpServerConfig()
 	if err == nil {
-		log.Printf("main: start httpServer, Bind=%v, Port=%v", httpServerConfig.Bind, httpServerConfig.Port)
+		log.Printf(
+			"main: start httpServer, Bind=%v, Port=%v, Tls=%v",
+			httpServerConfig.Bind, httpServerConfig.Port, httpServerConfig.Tls(),
+		)
 
 		env := &httpServer.Environment{
-			RoundedStorage:   roundedStorage,
-			Devices:          storage.GetAll(),
-			MqttClientConfig: mqttClientConfig,
+			RoundedStorage:    roundedStorage,
+			Devices:           storage.GetAll(),
+			MqttClientConfigs: mqttClientConfigs,
+			AlarmEngine:       alarmEngine,
+			History:           historyReader,
+		}
+
+		if httpServerConfig.WsDeadband {
+			env.WsDeadbandRules = getDeadbandRules()
+		}
+
+		if authConfig, err := config.GetAuthConfig(); err == nil {
+			log.Printf(
+				"main: httpServer authentication enabled, Tokens=%v, Users=%v, Certs=%v, Jwt=%v",
+				len(authConfig.Tokens), len(authConfig.Users), len(authConfig.Certs), len(authConfig.JwtSecret) > 0,
+			)
+			env.AuthConfig = authConfig
+		} else if err == config.ErrNoAuthConfig {
+			log.Printf("main: httpServer authentication disabled, all routes are public")
+		} else {
+			log.Fatalf("main: cannot setup httpServer authentication: %v", err)
 		}
 
-		httpServer.Run(httpServerConfig.Bind, httpServerConfig.Port, httpServerConfig.LogFile, env)
+		httpServer.Run(httpServerConfig, env)
 	} else {
 		log.Printf("main: skip httpServer, err=%v", err)
 	}
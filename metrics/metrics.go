@@ -0,0 +1,201 @@
+package metrics
+
+// a minimal registry of process internal metrics which are exposed in the prometheus text format
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var registry = make([]metric, 0)
+var registryMutex sync.Mutex
+
+func register(m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry = append(registry, m)
+}
+
+// WriteText writes all registered metrics in the prometheus text exposition format
+func WriteText(w io.Writer) {
+	registryMutex.Lock()
+	metrics := make([]metric, len(registry))
+	copy(metrics, registry)
+	registryMutex.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Vec is a counter or gauge with a fixed set of labels
+type Vec struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func CounterVecCreate(name, help string, labelNames ...string) *Vec {
+	return vecCreate(name, help, "counter", labelNames)
+}
+
+func GaugeVecCreate(name, help string, labelNames ...string) *Vec {
+	return vecCreate(name, help, "gauge", labelNames)
+}
+
+func vecCreate(name, help, metricType string, labelNames []string) *Vec {
+	vec := &Vec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+	}
+	register(vec)
+	return vec
+}
+
+// Add adds delta to the series identified by the label values which must be given in the order of the label names
+func (vec *Vec) Add(delta float64, labelValues ...string) {
+	key := vec.key(labelValues)
+	vec.mutex.Lock()
+	vec.values[key] += delta
+	vec.mutex.Unlock()
+}
+
+func (vec *Vec) Inc(labelValues ...string) {
+	vec.Add(1, labelValues...)
+}
+
+func (vec *Vec) Dec(labelValues ...string) {
+	vec.Add(-1, labelValues...)
+}
+
+func (vec *Vec) Set(value float64, labelValues ...string) {
+	key := vec.key(labelValues)
+	vec.mutex.Lock()
+	vec.values[key] = value
+	vec.mutex.Unlock()
+}
+
+func (vec *Vec) key(labelValues []string) string {
+	if len(labelValues) != len(vec.labelNames) {
+		panic(fmt.Sprintf("metrics: %v expects %v label values, got %v", vec.name, len(vec.labelNames), len(labelValues)))
+	}
+	return FormatLabels(vec.labelNames, labelValues)
+}
+
+func (vec *Vec) write(w io.Writer) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	WriteHeader(w, vec.name, vec.help, vec.metricType)
+
+	// an unlabeled metric is always written, even before it is updated
+	if len(vec.labelNames) == 0 && len(vec.values) == 0 {
+		WriteSample(w, vec.name, "", 0)
+		return
+	}
+
+	keys := make([]string, 0, len(vec.values))
+	for key := range vec.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		WriteSample(w, vec.name, key, vec.values[key])
+	}
+}
+
+// Summary counts observations and their sum per label set, e.g. for latencies in seconds
+type Summary struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mutex  sync.Mutex
+	counts map[string]uint64
+	sums   map[string]float64
+}
+
+func SummaryCreate(name, help string, labelNames ...string) *Summary {
+	summary := &Summary{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		counts:     make(map[string]uint64),
+		sums:       make(map[string]float64),
+	}
+	register(summary)
+	return summary
+}
+
+func (summary *Summary) Observe(value float64, labelValues ...string) {
+	key := FormatLabels(summary.labelNames, labelValues)
+	summary.mutex.Lock()
+	summary.counts[key]++
+	summary.sums[key] += value
+	summary.mutex.Unlock()
+}
+
+func (summary *Summary) write(w io.Writer) {
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	WriteHeader(w, summary.name, summary.help, "summary")
+
+	keys := make([]string, 0, len(summary.counts))
+	for key := range summary.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		WriteSample(w, summary.name+"_sum", key, summary.sums[key])
+		WriteSample(w, summary.name+"_count", key, float64(summary.counts[key]))
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// FormatLabels returns the label set as used in the text format, e.g. {device="bmv0",unit="V"}
+func FormatLabels(labelNames, labelValues []string) string {
+	if len(labelNames) < 1 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range labelNames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		if i < len(labelValues) {
+			b.WriteString(labelValueEscaper.Replace(labelValues[i]))
+		}
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func WriteHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func WriteSample(w io.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
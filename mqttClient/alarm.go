@@ -0,0 +1,29 @@
+package mqttClient
+
+import (
+	"encoding/json"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"strings"
+)
+
+func GetAlarmTopic(cfg *config.MqttClientConfig, deviceName string) string {
+	topic := replaceTemplate(cfg.AlarmTopic, cfg)
+	return strings.Replace(topic, "%DeviceName%", deviceName, 1)
+}
+
+func transmitAlarms(input <-chan alarm.Alarm, mqttClient *MqttClient) {
+	go func() {
+		cfg := mqttClient.config
+
+		for a := range input {
+			if !mqttClient.client.IsConnectionOpen() || mqttClient.filtered(a.Device, a.ValueName) {
+				continue
+			}
+
+			if b, err := json.Marshal(a.ConvertToMessage()); err == nil {
+				mqttClient.client.Publish(GetAlarmTopic(cfg, a.Device.Name), cfg.Qos, cfg.AlarmRetain, b)
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package mqttClient
+
+import (
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"strings"
+)
+
+func GetDeviceAvailableTopic(cfg *config.MqttClientConfig, deviceName string) string {
+	topic := replaceTemplate(cfg.DeviceAvailableTopic, cfg)
+	return strings.Replace(topic, "%DeviceName%", deviceName, 1)
+}
+
+// a device is considered Offline as soon as all its values are stale
+func transmitDeviceAvailability(input <-chan dataflow.Value, mqttClient *MqttClient) {
+	// the retained availability of all devices is published again after a reconnect
+	reconnected := make(chan struct{}, 1)
+	mqttClient.onConnect(func() {
+		select {
+		case reconnected <- struct{}{}:
+		default:
+		}
+	})
+
+	go func() {
+		cfg := mqttClient.config
+
+		staleValues := make(map[*storage.Device]map[string]bool)
+		online := make(map[*storage.Device]bool)
+		published := make(map[*storage.Device]bool)
+
+		publish := func(device *storage.Device) {
+			payload := "Offline"
+			if online[device] {
+				payload = "Online"
+			}
+			mqttClient.client.Publish(GetDeviceAvailableTopic(cfg, device.Name), cfg.Qos, true, payload)
+			published[device] = online[device]
+		}
+
+		for {
+			select {
+			case value, ok := <-input:
+				if !ok {
+					return
+				}
+
+				if _, ok := staleValues[value.Device]; !ok {
+					staleValues[value.Device] = make(map[string]bool)
+				}
+				staleValues[value.Device][value.Name] = value.Stale
+
+				online[value.Device] = false
+				for _, stale := range staleValues[value.Device] {
+					if !stale {
+						online[value.Device] = true
+						break
+					}
+				}
+
+				if last, ok := published[value.Device]; ok && last == online[value.Device] {
+					continue
+				}
+
+				if !mqttClient.client.IsConnectionOpen() {
+					continue
+				}
+
+				publish(value.Device)
+			case <-reconnected:
+				for device := range online {
+					publish(device)
+				}
+			}
+		}
+	}()
+}
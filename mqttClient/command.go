@@ -0,0 +1,115 @@
+package mqttClient
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/storage"
+	"github.com/koestler/go-ve-sensor/vedevices"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// actions which can be sent instead of a value name; the payload is ignored
+const (
+	commandActionReread  = "Reread"
+	commandActionRestart = "Restart"
+)
+
+// CommandResultMessage acknowledges every command received on a command topic
+type CommandResultMessage struct {
+	Time    string
+	Name    string
+	Payload string
+	Success bool
+	Error   string
+}
+
+func GetCommandResultTopic(cfg *config.MqttClientConfig, deviceName string) string {
+	topic := replaceTemplate(cfg.CommandResultTopic, cfg)
+	return strings.Replace(topic, "%DeviceName%", deviceName, 1)
+}
+
+// subscribeCommands subscribes to the command topic with wildcards for the device and value name
+func subscribeCommands(mqttClient *MqttClient) {
+	cfg := mqttClient.config
+
+	levels := strings.Split(replaceTemplate(cfg.CommandTopic, cfg), "/")
+	deviceLevel, valueLevel := -1, -1
+	for i, level := range levels {
+		switch level {
+		case "%DeviceName%":
+			deviceLevel = i
+			levels[i] = "+"
+		case "%ValueName%":
+			valueLevel = i
+			levels[i] = "+"
+		}
+	}
+
+	subscription := strings.Join(levels, "/")
+	mqttClient.subscribe(subscription, func(client mqtt.Client, message mqtt.Message) {
+		topicLevels := strings.Split(message.Topic(), "/")
+		if len(topicLevels) != len(levels) {
+			return
+		}
+
+		// commands may take a while to execute; do not block the delivery of other messages
+		go handleCommand(mqttClient, topicLevels[deviceLevel], topicLevels[valueLevel], string(message.Payload()))
+	})
+
+	log.Printf("mqttClient: subscribed to commands on %v", subscription)
+}
+
+func handleCommand(mqttClient *MqttClient, deviceName, name, payload string) {
+	cfg := mqttClient.config
+
+	err := func() error {
+		if !cfg.CommandAllowed(deviceName, name) {
+			return errors.New("command not allowed")
+		}
+
+		device, err := storage.GetByName(deviceName)
+		if err != nil {
+			return err
+		}
+		if mqttClient.deviceFiltered(device) {
+			return errors.New("device is not published to this broker")
+		}
+
+		command := vedevices.Command{Name: name}
+		switch name {
+		case commandActionReread:
+			command.Type = vedevices.CommandReread
+		case commandActionRestart:
+			command.Type = vedevices.CommandRestart
+		default:
+			command.Type = vedevices.CommandSet
+			if command.Value, err = strconv.ParseFloat(strings.TrimSpace(payload), 64); err != nil {
+				return errors.New("payload is not a number")
+			}
+		}
+
+		return vedevices.SendCommand(device, command)
+	}()
+
+	result := CommandResultMessage{
+		Time:    timeToString(time.Now()),
+		Name:    name,
+		Payload: payload,
+		Success: err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("mqttClient: command failed device=%v name=%v payload=%v err=%v", deviceName, name, payload, err)
+	} else {
+		log.Printf("mqttClient: command executed device=%v name=%v payload=%v", deviceName, name, payload)
+	}
+
+	if b, err := json.Marshal(result); err == nil {
+		mqttClient.client.Publish(GetCommandResultTopic(cfg, deviceName), cfg.Qos, false, b)
+	}
+}
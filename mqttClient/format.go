@@ -0,0 +1,207 @@
+package mqttClient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/lineProtocol"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// payloadFormatter creates the realtime and telemetry payloads according to the configured formats;
+// the default json payloads do not depend on JsonFields and TimeFormat if those are not set
+type payloadFormatter struct {
+	config            *config.MqttClientConfig
+	fieldNames        map[string]string
+	realtimeTemplate  *template.Template
+	telemetryTemplate *template.Template
+}
+
+func payloadFormatterCreate(cfg *config.MqttClientConfig) (formatter *payloadFormatter) {
+	// JsonFields and the templates have been checked by config
+	fieldNames, _ := cfg.JsonFieldNames()
+
+	formatter = &payloadFormatter{
+		config:     cfg,
+		fieldNames: fieldNames,
+	}
+
+	funcs := template.FuncMap{"formatTime": formatter.formatTime}
+	if cfg.RealtimeFormat == "Template" {
+		formatter.realtimeTemplate = template.Must(template.New("Realtime").Funcs(funcs).Parse(cfg.RealtimeTemplate))
+	}
+	if cfg.TelemetryFormat == "Template" {
+		formatter.telemetryTemplate = template.Must(template.New("Telemetry").Funcs(funcs).Parse(cfg.TelemetryTemplate))
+	}
+
+	return
+}
+
+func (formatter *payloadFormatter) realtime(message RealtimeMessage) ([]byte, error) {
+	switch formatter.config.RealtimeFormat {
+	case "Plain":
+		return []byte(formatFloat(message.Value)), nil
+	case "Influx":
+		return []byte(lineProtocol.Point{
+			Measurement: message.Model,
+			Device:      message.DeviceName,
+			Unit:        message.Unit,
+			Fields:      map[string]float64{message.Name: message.Value},
+			Time:        message.Time,
+		}.Line(time.Nanosecond)), nil
+	case "Template":
+		return executeTemplate(formatter.realtimeTemplate, message)
+	}
+
+	return json.Marshal(formatter.jsonObject(
+		jsonField{"Time", formatter.formatTime(message.Time)},
+		jsonField{"Value", message.Value},
+		jsonField{"Unit", message.Unit},
+	))
+}
+
+func (formatter *payloadFormatter) telemetry(message TelemetryMessage) ([]byte, error) {
+	switch formatter.config.TelemetryFormat {
+	case "Influx":
+		fields := make(map[string]float64, len(message.Values))
+		for name, value := range message.Values {
+			if !value.Stale {
+				fields[name] = value.Value
+			}
+		}
+		if len(fields) < 1 {
+			return nil, errors.New("no fields")
+		}
+		return []byte(lineProtocol.Point{
+			Measurement: message.Model,
+			Device:      message.DeviceName,
+			Fields:      fields,
+			Time:        message.Time,
+		}.Line(time.Nanosecond)), nil
+	case "Template":
+		return executeTemplate(formatter.telemetryTemplate, message)
+	}
+
+	values := make(map[string]jsonObject, len(message.Values))
+	for name, value := range message.Values {
+		values[name] = formatter.jsonObject(
+			jsonField{"Value", value.Value},
+			jsonField{"Unit", value.Unit},
+			jsonField{"Stale", value.Stale},
+			jsonField{"Restored", value.Restored},
+		)
+	}
+
+	return json.Marshal(formatter.jsonObject(
+		jsonField{"Time", formatter.formatTime(message.Time)},
+		jsonField{"NextTele", formatter.formatTime(message.NextTele)},
+		jsonField{"TimeZone", "UTC"},
+		jsonField{"Model", message.Model},
+		jsonField{"Values", values},
+	))
+}
+
+// formatTime is also available as function in the templates, e.g. {{formatTime .Time}}
+func (formatter *payloadFormatter) formatTime(t time.Time) interface{} {
+	switch formatter.config.TimeFormat {
+	case "RFC3339":
+		return t.UTC().Format(time.RFC3339)
+	case "EpochMs":
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return timeToString(t)
+}
+
+// fieldName returns the configured name of a json field and false if the field is omitted
+func (formatter *payloadFormatter) fieldName(name string) (string, bool) {
+	if renamed, ok := formatter.fieldNames[name]; ok {
+		return renamed, renamed != "-"
+	}
+	return name, true
+}
+
+// hassRealtimeTemplate returns the home assistant value template for realtime payloads;
+// empty if home assistant cannot extract the value
+func (formatter *payloadFormatter) hassRealtimeTemplate() string {
+	switch formatter.config.RealtimeFormat {
+	case "Plain":
+		return "{{ value }}"
+	case "Json":
+		if value, ok := formatter.fieldName("Value"); ok {
+			return "{{ value_json." + value + " }}"
+		}
+	}
+	return ""
+}
+
+// GetHassRealtimeTemplate returns the home assistant value template for the realtime payloads of the given config;
+// empty if home assistant cannot extract the value
+func GetHassRealtimeTemplate(cfg *config.MqttClientConfig) string {
+	return payloadFormatterCreate(cfg).hassRealtimeTemplate()
+}
+
+// hassTelemetryTemplate returns the home assistant value template for the given value of telemetry payloads;
+// empty if home assistant cannot extract the value
+func (formatter *payloadFormatter) hassTelemetryTemplate(valueName string) string {
+	if formatter.config.TelemetryFormat != "Json" {
+		return ""
+	}
+	values, okValues := formatter.fieldName("Values")
+	value, okValue := formatter.fieldName("Value")
+	if !okValues || !okValue {
+		return ""
+	}
+	return "{{ value_json." + values + "." + valueName + "." + value + " }}"
+}
+
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+// jsonObject keeps the order of the fields when marshalled
+type jsonObject []jsonField
+
+func (formatter *payloadFormatter) jsonObject(fields ...jsonField) (object jsonObject) {
+	object = make(jsonObject, 0, len(fields))
+	for _, field := range fields {
+		if name, ok := formatter.fieldName(field.name); ok {
+			object = append(object, jsonField{name, field.value})
+		}
+	}
+	return
+}
+
+func (object jsonObject) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, field := range object {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		name, err := json.Marshal(field.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(append(b, name...), ':'), value...)
+	}
+	return append(b, '}'), nil
+}
+
+func executeTemplate(tmpl *template.Template, data interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
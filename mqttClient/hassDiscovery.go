@@ -0,0 +1,354 @@
+package mqttClient
+
+import (
+	"encoding/json"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/koestler/go-ve-sensor/config"
+	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// home assistant mqtt discovery, see https://www.home-assistant.io/docs/mqtt/discovery/
+// A sensor config is published as soon as a value is seen for the first time. Configs of devices which are no
+// longer configured are removed on startup; configs of values which have been stale or not reported for
+// HassDiscoveryExpire are removed at runtime, including the retained configs found on startup.
+// All configs are published again when home assistant comes online.
+
+type hassSensorConfig struct {
+	Name              string             `json:"name"`
+	UniqueId          string             `json:"unique_id"`
+	ObjectId          string             `json:"object_id"`
+	StateTopic        string             `json:"state_topic"`
+	ValueTemplate     string             `json:"value_template"`
+	UnitOfMeasurement string             `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string             `json:"device_class,omitempty"`
+	StateClass        string             `json:"state_class,omitempty"`
+	Availability      []hassAvailability `json:"availability,omitempty"`
+	AvailabilityMode  string             `json:"availability_mode,omitempty"`
+	Device            hassDevice         `json:"device"`
+}
+
+type hassAvailability struct {
+	Topic               string `json:"topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+}
+
+type hassDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+type hassClass struct {
+	unit        string
+	deviceClass string
+	stateClass  string
+}
+
+// maps the units of the registers to the unit, device class and state class used by home assistant
+var hassClasses = map[string]hassClass{
+	"V":   {"V", "voltage", "measurement"},
+	"mV":  {"mV", "voltage", "measurement"},
+	"A":   {"A", "current", "measurement"},
+	"W":   {"W", "power", "measurement"},
+	"kWh": {"kWh", "energy", "total_increasing"},
+	"K":   {"K", "temperature", "measurement"},
+	"C":   {"°C", "temperature", "measurement"},
+	"min": {"min", "duration", "measurement"},
+	"h":   {"h", "duration", "measurement"},
+}
+
+// time to wait for the retained configs after subscribing to them before stale configs are removed
+const hassCleanupDelay = 5 * time.Second
+
+// configs of values not reported for HassDiscoveryExpire are removed by a check with this interval
+const hassExpireCheckInterval = time.Minute
+
+var hassIdReplace = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func hassId(s string) string {
+	return hassIdReplace.ReplaceAllString(s, "_")
+}
+
+func getHassNodeId(cfg *config.MqttClientConfig) string {
+	return hassId(cfg.ClientId)
+}
+
+func getHassDeviceIdentifier(cfg *config.MqttClientConfig, deviceName string) string {
+	return getHassNodeId(cfg) + "_" + hassId(deviceName)
+}
+
+func GetHassDiscoveryTopic(cfg *config.MqttClientConfig, objectId string) string {
+	return cfg.HassDiscoveryPrefix + "/sensor/" + getHassNodeId(cfg) + "/" + objectId + "/config"
+}
+
+func getHassStatusTopic(cfg *config.MqttClientConfig) string {
+	return cfg.HassDiscoveryPrefix + "/status"
+}
+
+// getHassState returns the template home assistant extracts the state of a value with and whether it is
+// read from the realtime or the telemetry messages; realtime messages are preferred
+func getHassState(mqttClient *MqttClient, valueName string) (template string, realtime bool, ok bool) {
+	cfg := mqttClient.config
+
+	if template = mqttClient.formatter.hassRealtimeTemplate(); cfg.RealtimeEnable && len(template) > 0 {
+		return template, true, true
+	}
+	if template = mqttClient.formatter.hassTelemetryTemplate(valueName); getTelemetryInterval(cfg) > 0 && len(template) > 0 {
+		return template, false, true
+	}
+	return "", false, false
+}
+
+func createHassSensorConfig(mqttClient *MqttClient, value dataflow.Value) hassSensorConfig {
+	cfg := mqttClient.config
+	objectId := hassId(value.Device.Name) + "_" + hassId(value.Name)
+
+	sensor := hassSensorConfig{
+		Name:              value.Device.Name + " " + value.Name,
+		UniqueId:          getHassNodeId(cfg) + "_" + objectId,
+		ObjectId:          objectId,
+		UnitOfMeasurement: value.Unit,
+		StateClass:        "measurement",
+		Device: hassDevice{
+			Identifiers:  []string{getHassDeviceIdentifier(cfg, value.Device.Name)},
+			Name:         value.Device.Name,
+			Model:        value.Device.Model,
+			Manufacturer: "Victron Energy",
+		},
+	}
+
+	if class, ok := hassClasses[value.Unit]; ok {
+		sensor.UnitOfMeasurement = class.unit
+		sensor.DeviceClass = class.deviceClass
+		sensor.StateClass = class.stateClass
+	} else if value.Unit == "%" && value.Name == "StateOfCharge" {
+		sensor.DeviceClass = "battery"
+	}
+
+	template, realtime, _ := getHassState(mqttClient, value.Name)
+	sensor.ValueTemplate = template
+	if realtime {
+		sensor.StateTopic = GetRealtimeTopic(cfg, value.Device.Name, value.Device.Model, value.Name, value.Unit)
+	} else {
+		sensor.StateTopic = GetTelemetryTopic(cfg, value.Device.Name)
+	}
+
+	if cfg.AvailableEnable {
+		sensor.Availability = append(sensor.Availability, hassAvailability{
+			Topic:               GetAvailableTopic(cfg),
+			PayloadAvailable:    "Online",
+			PayloadNotAvailable: "Offline",
+		})
+	}
+	if cfg.DeviceAvailableEnable {
+		sensor.Availability = append(sensor.Availability, hassAvailability{
+			Topic:               GetDeviceAvailableTopic(cfg, value.Device.Name),
+			PayloadAvailable:    "Online",
+			PayloadNotAvailable: "Offline",
+		})
+	}
+	if len(sensor.Availability) > 1 {
+		sensor.AvailabilityMode = "all"
+	}
+
+	return sensor
+}
+
+// hassPublished is a config published by this node; the payload is nil for retained configs
+// found at startup whose value has not been reported since
+type hassPublished struct {
+	payload []byte
+	// zero while the value is reported
+	staleSince time.Time
+}
+
+func transmitHassDiscovery(input <-chan dataflow.Value, mqttClient *MqttClient) {
+	cfg := mqttClient.config
+	expire, _ := time.ParseDuration(cfg.HassDiscoveryExpire)
+
+	// configs are sent again after a reconnect and when home assistant publishes online to its status topic after a restart
+	republish := make(chan struct{}, 1)
+	triggerRepublish := func() {
+		select {
+		case republish <- struct{}{}:
+		default:
+		}
+	}
+	mqttClient.subscribe(getHassStatusTopic(cfg), func(client mqtt.Client, message mqtt.Message) {
+		if string(message.Payload()) == "online" {
+			triggerRepublish()
+		}
+	})
+	mqttClient.onConnect(triggerRepublish)
+
+	// stale configs are removed once, as soon as the retained configs can be read;
+	// the retained configs of configured devices are handed over to expire if their value is never reported
+	retained := make(chan map[string][]string, 1)
+	var cleanupOnce sync.Once
+	mqttClient.onConnect(func() {
+		cleanupOnce.Do(func() {
+			cleanupHassDiscovery(mqttClient, retained)
+		})
+	})
+
+	go func() {
+		// a nil channel blocks forever -> expiry is disabled
+		var expireCheck <-chan time.Time
+		if expire > 0 {
+			ticker := time.NewTicker(hassExpireCheckInterval)
+			defer ticker.Stop()
+			expireCheck = ticker.C
+		}
+
+		// device identifier -> object id -> published config
+		published := make(map[string]map[string]*hassPublished)
+
+		for {
+			select {
+			case value, ok := <-input:
+				if !ok {
+					return
+				}
+
+				sensor := createHassSensorConfig(mqttClient, value)
+				deviceIdentifier := getHassDeviceIdentifier(cfg, value.Device.Name)
+				if _, ok := published[deviceIdentifier]; !ok {
+					published[deviceIdentifier] = make(map[string]*hassPublished)
+				}
+
+				entry, ok := published[deviceIdentifier][sensor.ObjectId]
+				if !ok {
+					entry = &hassPublished{}
+					published[deviceIdentifier][sensor.ObjectId] = entry
+				}
+				if !value.Stale {
+					entry.staleSince = time.Time{}
+				} else if entry.staleSince.IsZero() {
+					entry.staleSince = time.Now()
+				}
+				if entry.payload != nil {
+					continue
+				}
+
+				b, err := json.Marshal(sensor)
+				if err != nil {
+					continue
+				}
+				entry.payload = b
+
+				if mqttClient.client.IsConnectionOpen() {
+					mqttClient.client.Publish(GetHassDiscoveryTopic(cfg, sensor.ObjectId), cfg.Qos, true, b)
+				}
+			case <-republish:
+				count := 0
+				for _, objects := range published {
+					for objectId, entry := range objects {
+						if entry.payload != nil {
+							mqttClient.client.Publish(GetHassDiscoveryTopic(cfg, objectId), cfg.Qos, true, entry.payload)
+							count++
+						}
+					}
+				}
+				log.Printf("mqttClient: home assistant online, publish %v discovery configs", count)
+			case objectIds := <-retained:
+				now := time.Now()
+				for deviceIdentifier, ids := range objectIds {
+					if _, ok := published[deviceIdentifier]; !ok {
+						published[deviceIdentifier] = make(map[string]*hassPublished)
+					}
+					for _, objectId := range ids {
+						if _, ok := published[deviceIdentifier][objectId]; !ok {
+							published[deviceIdentifier][objectId] = &hassPublished{staleSince: now}
+						}
+					}
+				}
+			case now := <-expireCheck:
+				if !mqttClient.client.IsConnectionOpen() {
+					// try again on the next check
+					continue
+				}
+				for deviceIdentifier, objects := range published {
+					for objectId, entry := range objects {
+						if entry.staleSince.IsZero() || now.Sub(entry.staleSince) < expire {
+							continue
+						}
+						log.Printf("mqttClient: remove home assistant discovery config of value not reported since %v, device=%v, objectId=%v",
+							entry.staleSince.Format(time.RFC3339), deviceIdentifier, objectId)
+						mqttClient.client.Publish(GetHassDiscoveryTopic(cfg, objectId), cfg.Qos, true, []byte{})
+						delete(objects, objectId)
+					}
+					if len(objects) < 1 {
+						delete(published, deviceIdentifier)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// cleanupHassDiscovery reads the retained configs of this node and removes the ones of devices
+// which are no longer configured by publishing an empty retained message;
+// the object ids of the other retained configs are sent to retained, grouped by device identifier
+func cleanupHassDiscovery(mqttClient *MqttClient, retained chan<- map[string][]string) {
+	cfg := mqttClient.config
+
+	configured := make(map[string]bool)
+	for _, device := range storage.GetAll() {
+		// configs of devices which are not published to this broker are removed as well
+		if mqttClient.deviceFiltered(device) {
+			continue
+		}
+		configured[getHassDeviceIdentifier(cfg, device.Name)] = true
+	}
+
+	// written by the subscription callback, read once the retained messages have been received
+	var mutex sync.Mutex
+	stale := make(map[string]bool)
+	objectIds := make(map[string][]string)
+
+	wildcard := GetHassDiscoveryTopic(cfg, "+")
+	mqttClient.client.Subscribe(wildcard, cfg.Qos, func(client mqtt.Client, message mqtt.Message) {
+		if len(message.Payload()) < 1 {
+			// already removed
+			return
+		}
+
+		var sensor hassSensorConfig
+		if err := json.Unmarshal(message.Payload(), &sensor); err != nil {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, identifier := range sensor.Device.Identifiers {
+			if configured[identifier] {
+				// the object id is the second last level of the topic
+				levels := strings.Split(message.Topic(), "/")
+				objectIds[identifier] = append(objectIds[identifier], levels[len(levels)-2])
+				return
+			}
+		}
+		stale[message.Topic()] = true
+	})
+
+	go func() {
+		time.Sleep(hassCleanupDelay)
+		mqttClient.client.Unsubscribe(wildcard).Wait()
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		for topic := range stale {
+			log.Printf("mqttClient: remove home assistant discovery config of unknown device, topic=%v", topic)
+			mqttClient.client.Publish(topic, cfg.Qos, true, []byte{})
+		}
+		retained <- objectIds
+	}()
+}
@@ -1,22 +1,49 @@
 package mqttClient
 
 import (
+	"fmt"
 	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/koestler/go-ve-sensor/alarm"
 	"github.com/koestler/go-ve-sensor/config"
 	"github.com/koestler/go-ve-sensor/dataflow"
+	"github.com/koestler/go-ve-sensor/storage"
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 type MqttClient struct {
-	config *config.MqttClientConfig
-	client mqtt.Client
+	config    *config.MqttClientConfig
+	client    mqtt.Client
+	formatter *payloadFormatter
+	filter    dataflow.Filter
+
+	// the session is not resumed by the broker; subscriptions are made again and the
+	// onConnect hooks are run after every (re)connect.
+	// client.IsConnected is also true while paho reconnects, IsConnectionOpen is used to
+	// not pass messages to paho which would be sent out of order after the reconnect
+	mutex         sync.Mutex
+	subscriptions map[string]mqtt.MessageHandler
+	hooks         []func()
 }
 
-func Run(config *config.MqttClientConfig, storage *dataflow.ValueStorageInstance) (mqttClient *MqttClient) {
-	// configure client and start connection
+func Run(
+	config *config.MqttClientConfig,
+	storage *dataflow.ValueStorageInstance,
+	alarmEngine *alarm.Engine,
+	deadbandRules *dataflow.DeadbandRules,
+) (mqttClient *MqttClient) {
+	maxReconnectInterval, _ := time.ParseDuration(config.MaxReconnectInterval)
+
+	mqttClient = &MqttClient{
+		config:        config,
+		formatter:     payloadFormatterCreate(config),
+		subscriptions: make(map[string]mqtt.MessageHandler),
+	}
+
+	// configure client; paho reconnects with an exponential back-off once connected
 	opts := mqtt.NewClientOptions().AddBroker(config.Broker).SetClientID(config.ClientId)
 	if len(config.User) > 0 {
 		opts.SetUsername(config.User)
@@ -24,11 +51,22 @@ func Run(config *config.MqttClientConfig, storage *dataflow.ValueStorageInstance
 	if len(config.Password) > 0 {
 		opts.SetPassword(config.Password)
 	}
-
-	availableTopic := GetAvailableTopic(config)
+	if config.Tls() {
+		tlsConfig, err := createTlsConfig(config)
+		if err != nil {
+			log.Fatalf("mqttClient: cannot setup tls: %v", err)
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(maxReconnectInterval)
+	opts.SetOnConnectHandler(mqttClient.connected)
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Printf("mqttClient: connection to %v lost, reconnect: %v", config.Broker, err)
+	})
 
 	if (config.AvailableEnable) {
-		opts.SetWill(availableTopic, "Offline", config.Qos, true)
+		opts.SetWill(GetAvailableTopic(config), "Offline", config.Qos, true)
 	}
 
 	mqtt.ERROR = log.New(os.Stdout, "", 0)
@@ -36,42 +74,164 @@ func Run(config *config.MqttClientConfig, storage *dataflow.ValueStorageInstance
 		mqtt.DEBUG = log.New(os.Stdout, "", 0)
 	}
 
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal("mqttClient connect failed", token.Error())
-	}
-	log.Printf("mqttClient: connected to %v", config.Broker)
+	mqttClient.client = mqtt.NewClient(opts)
 
-	mqttClient = &MqttClient{
-		config: config,
-		client: client,
+	// setup filter of the devices and values published to this broker; empty: everything
+	storageFilter, err := createStorageFilter(config)
+	if err != nil {
+		log.Fatalf("mqttClient: cannot setup filter: %v", err)
 	}
-
-	// send Online
-	if (config.AvailableEnable) {
-		client.Publish(availableTopic, config.Qos, true, "Online")
-	}
-
-	// setup empty filter (everything)
-	storageFilter := dataflow.Filter{}
+	mqttClient.filter = storageFilter
 
 	// setup Realtime (send data as soon as it arrives) output
 	if config.RealtimeEnable {
 		// transmitRealtime values from data store and publish to mqtt broker
 		dataChan := storage.Subscribe(storageFilter)
+
+		// optionally suppress insignificant changes and limit the publish rate
+		if config.RealtimeDeadband {
+			deadband := dataflow.DeadbandCreate(deadbandRules)
+			deadband.Fill(dataChan)
+			dataChan = deadband.Drain()
+		}
+
 		log.Print("mqtttClient: start sending realtime stat messages")
-		transmitRealtime(dataChan, mqttClient)
+		transmitRealtime(storage, storageFilter, dataChan, mqttClient)
+	}
+
+	// setup per device availability output (Online / Offline when all values are stale)
+	if config.DeviceAvailableEnable {
+		log.Print("mqtttClient: start sending device availability messages")
+		transmitDeviceAvailability(storage.Subscribe(storageFilter), mqttClient)
 	}
 
 	// setup Telemetry support
-	if interval, err := time.ParseDuration(config.TelemetryInterval); err == nil && interval > 0 {
+	if interval := getTelemetryInterval(config); interval > 0 {
 		log.Printf("mqtttClient: start sending telemetry messages every %s", interval.String())
 		transmitTelemetry(storage, storageFilter, interval, mqttClient)
 	}
 
+	// setup home assistant mqtt discovery
+	if config.HassDiscoveryEnable {
+		if _, _, ok := getHassState(mqttClient, "Value"); !ok {
+			log.Print("mqtttClient: skip home assistant discovery, realtime or telemetry messages in a format home assistant can parse are needed")
+		} else {
+			log.Printf("mqtttClient: start sending home assistant discovery configs to %v", config.HassDiscoveryPrefix)
+			transmitHassDiscovery(storage.Subscribe(storageFilter), mqttClient)
+		}
+	}
+
+	// setup command topics to set registers and trigger actions
+	if config.CommandEnable {
+		if len(config.CommandAllow) < 1 {
+			log.Print("mqtttClient: CommandAllow is empty, all commands will be rejected")
+		}
+		subscribeCommands(mqttClient)
+	}
+
+	// setup Alarm events output
+	if config.AlarmEnable && alarmEngine != nil {
+		log.Print("mqtttClient: start sending alarm messages")
+		transmitAlarms(alarmEngine.Subscribe(), mqttClient)
+	}
+
+	// the first connect is retried in the background; paho only reconnects connections which have been up once
+	go mqttClient.connect(maxReconnectInterval)
+
 	return
 }
 
+func (mqttClient *MqttClient) connect(maxInterval time.Duration) {
+	interval := time.Second
+	for {
+		token := mqttClient.client.Connect()
+		if token.Wait() && token.Error() == nil {
+			return
+		}
+
+		log.Printf("mqttClient: connect to %v failed, retry in %v: %v", mqttClient.config.Broker, interval, token.Error())
+		time.Sleep(interval)
+
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// connected is called by paho after every successful (re)connect
+func (mqttClient *MqttClient) connected(client mqtt.Client) {
+	cfg := mqttClient.config
+	log.Printf("mqttClient: connected to %v", cfg.Broker)
+
+	// send Online
+	if cfg.AvailableEnable {
+		client.Publish(GetAvailableTopic(cfg), cfg.Qos, true, "Online")
+	}
+
+	mqttClient.mutex.Lock()
+	defer mqttClient.mutex.Unlock()
+
+	for topic, callback := range mqttClient.subscriptions {
+		client.Subscribe(topic, cfg.Qos, callback)
+	}
+	for _, hook := range mqttClient.hooks {
+		go hook()
+	}
+}
+
+// subscribe subscribes to the topic now if connected and again after every reconnect
+func (mqttClient *MqttClient) subscribe(topic string, callback mqtt.MessageHandler) {
+	mqttClient.mutex.Lock()
+	defer mqttClient.mutex.Unlock()
+
+	mqttClient.subscriptions[topic] = callback
+	if mqttClient.client.IsConnectionOpen() {
+		mqttClient.client.Subscribe(topic, mqttClient.config.Qos, callback)
+	}
+}
+
+// onConnect registers a hook which is run after every (re)connect, e.g. to publish the current state again
+func (mqttClient *MqttClient) onConnect(hook func()) {
+	mqttClient.mutex.Lock()
+	defer mqttClient.mutex.Unlock()
+
+	mqttClient.hooks = append(mqttClient.hooks, hook)
+}
+
+// createStorageFilter fails on unknown devices since an empty device filter would publish all devices
+func createStorageFilter(cfg *config.MqttClientConfig) (filter dataflow.Filter, err error) {
+	if deviceNames := cfg.DeviceNames(); len(deviceNames) > 0 {
+		filter.Devices = make(map[*storage.Device]bool)
+		for _, deviceName := range deviceNames {
+			device, err := storage.GetByName(deviceName)
+			if err != nil {
+				return filter, fmt.Errorf("unknown device=%v in Devices", deviceName)
+			}
+			filter.Devices[device] = true
+		}
+	}
+
+	if valueNames := cfg.ValueNames(); len(valueNames) > 0 {
+		filter.ValueNames = make(map[string]bool)
+		for _, valueName := range valueNames {
+			filter.ValueNames[valueName] = true
+		}
+	}
+
+	return
+}
+
+// deviceFiltered returns true if the device is not published to this broker
+func (mqttClient *MqttClient) deviceFiltered(device *storage.Device) bool {
+	return mqttClient.filter.Devices != nil && !mqttClient.filter.Devices[device]
+}
+
+// filtered returns true if the device or the value is not published to this broker
+func (mqttClient *MqttClient) filtered(device *storage.Device, valueName string) bool {
+	return mqttClient.deviceFiltered(device) ||
+		(len(mqttClient.filter.ValueNames) > 0 && !mqttClient.filter.ValueNames[valueName])
+}
+
 func GetAvailableTopic(cfg *config.MqttClientConfig) string {
 	return replaceTemplate(cfg.AvailableTopic, cfg)
 }
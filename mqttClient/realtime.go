@@ -1,24 +1,30 @@
 package mqttClient
 
 import (
-	"encoding/json"
 	"github.com/koestler/go-ve-sensor/config"
 	"github.com/koestler/go-ve-sensor/dataflow"
 	"strings"
 	"time"
 )
 
+// RealtimeMessage is formatted according to RealtimeFormat; it is also the data of RealtimeTemplate
 type RealtimeMessage struct {
-	Time  string
-	Value float64
-	Unit  string
+	Time       time.Time
+	DeviceName string
+	Model      string
+	Name       string
+	Value      float64
+	Unit       string
 }
 
 func convertValueToRealtimeMessage(value dataflow.Value) (RealtimeMessage) {
 	return RealtimeMessage{
-		Time:  timeToString(time.Now()),
-		Value: value.Value,
-		Unit:  value.Unit,
+		Time:       value.Time,
+		DeviceName: value.Device.Name,
+		Model:      value.Device.Model,
+		Name:       value.Name,
+		Value:      value.Value,
+		Unit:       value.Unit,
 	}
 }
 
@@ -40,29 +46,87 @@ func GetRealtimeTopic(
 	return topic
 }
 
-func transmitRealtime(input <-chan dataflow.Value, mqttClient *MqttClient) {
-	go func() {
-		cfg := mqttClient.config
+func transmitRealtime(
+	storage *dataflow.ValueStorageInstance,
+	filter dataflow.Filter,
+	input <-chan dataflow.Value,
+	mqttClient *MqttClient,
+) {
+	// the current state is published again after a reconnect by the same go routine as the changes
+	reconnected := make(chan struct{}, 1)
+	mqttClient.onConnect(func() {
+		select {
+		case reconnected <- struct{}{}:
+		default:
+		}
+	})
 
-		for value := range input {
-			if !mqttClient.client.IsConnected() {
-				continue
-			}
+	go func() {
+		// the state is fetched in a separate go routine since the storage blocks until the input is drained;
+		// changes received meanwhile are held back and published after the state
+		var stateChan chan dataflow.State
+		var pending []dataflow.Value
 
-			if b, err := json.Marshal(convertValueToRealtimeMessage(value)); err == nil {
-				mqttClient.client.Publish(
-					GetRealtimeTopic(
-						cfg,
-						value.Device.Name,
-						value.Device.Model,
-						value.Name,
-						value.Unit,
-					),
-					cfg.Qos,
-					cfg.RealtimeRetain,
-					b,
-				)
+		for {
+			select {
+			case value, ok := <-input:
+				if !ok {
+					return
+				}
+				if stateChan != nil {
+					pending = append(pending, value)
+					continue
+				}
+				// values are dropped while disconnected; the current state is published again after the reconnect
+				if !mqttClient.client.IsConnectionOpen() {
+					continue
+				}
+				publishRealtime(value, mqttClient)
+			case <-reconnected:
+				if stateChan != nil {
+					continue
+				}
+				stateChan = make(chan dataflow.State, 1)
+				pending = make([]dataflow.Value, 0)
+				go func(response chan<- dataflow.State) {
+					response <- storage.GetState(filter)
+				}(stateChan)
+			case state := <-stateChan:
+				publishRealtimeState(state, mqttClient)
+				for _, value := range pending {
+					publishRealtime(value, mqttClient)
+				}
+				stateChan = nil
+				pending = nil
 			}
 		}
 	}()
 }
+
+// publishRealtimeState publishes the current value of every device and value, used after a (re)connect
+func publishRealtimeState(state dataflow.State, mqttClient *MqttClient) {
+	for _, valueMap := range state {
+		for _, value := range valueMap {
+			publishRealtime(value, mqttClient)
+		}
+	}
+}
+
+func publishRealtime(value dataflow.Value, mqttClient *MqttClient) {
+	cfg := mqttClient.config
+
+	if b, err := mqttClient.formatter.realtime(convertValueToRealtimeMessage(value)); err == nil {
+		mqttClient.client.Publish(
+			GetRealtimeTopic(
+				cfg,
+				value.Device.Name,
+				value.Device.Model,
+				value.Name,
+				value.Unit,
+			),
+			cfg.Qos,
+			cfg.RealtimeRetain,
+			b,
+		)
+	}
+}
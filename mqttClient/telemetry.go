@@ -1,18 +1,32 @@
 package mqttClient
 
 import (
-	"encoding/json"
+	"github.com/koestler/go-ve-sensor/config"
 	"github.com/koestler/go-ve-sensor/dataflow"
 	"strings"
 	"time"
 )
 
+// TelemetryMessage is formatted according to TelemetryFormat; it is also the data of TelemetryTemplate
 type TelemetryMessage struct {
-	Time     string
-	NextTele string
-	TimeZone string
-	Model    string
-	Values   dataflow.ValueEssentialMap
+	Time       time.Time
+	NextTele   time.Time
+	DeviceName string
+	Model      string
+	Values     dataflow.ValueEssentialMap
+}
+
+func GetTelemetryTopic(cfg *config.MqttClientConfig, deviceName string) string {
+	topic := replaceTemplate(cfg.TelemetryTopic, cfg)
+	return strings.Replace(topic, "%DeviceName%", deviceName, 1)
+}
+
+// getTelemetryInterval returns 0 if telemetry messages are disabled
+func getTelemetryInterval(cfg *config.MqttClientConfig) time.Duration {
+	if interval, err := time.ParseDuration(cfg.TelemetryInterval); err == nil && interval > 0 {
+		return interval
+	}
+	return 0
 }
 
 func transmitTelemetry(
@@ -21,24 +35,46 @@ func transmitTelemetry(
 	interval time.Duration,
 	mqttClient *MqttClient,
 ) {
-	go func() {
-		cfg := mqttClient.config
+	cfg := mqttClient.config
+
+	// messages are queued while disconnected such that no interval is lost
+	queue := telemetryQueueCreate(cfg.TelemetryQueueSize, cfg.TelemetryQueueFile)
+	mqttClient.onConnect(func() {
+		queue.flush(mqttClient)
+	})
 
+	go func() {
 		for now := range time.Tick(interval) {
 			for device, deviceState := range storage.GetState(filter) {
-				topic := replaceTemplate(cfg.TelemetryTopic, cfg)
-				topic = strings.Replace(topic, "%DeviceName%", device.Name, 1)
+				// do not republish old values of devices which are offline
+				if deviceState.Stale() {
+					continue
+				}
+
+				topic := GetTelemetryTopic(cfg, device.Name)
 
 				payload := TelemetryMessage{
-					Time:     timeToString(now.UTC()),
-					NextTele: timeToString(now.Add(interval)),
-					TimeZone: "UTC",
-					Model:    device.Model,
-					Values:   deviceState.ConvertToEssential(),
+					Time:       now,
+					NextTele:   now.Add(interval),
+					DeviceName: device.Name,
+					Model:      device.Model,
+					Values:     deviceState.ConvertToEssential(),
+				}
+
+				b, err := mqttClient.formatter.telemetry(payload)
+				if err != nil {
+					continue
 				}
 
-				if b, err := json.Marshal(payload); err == nil {
+				if mqttClient.client.IsConnectionOpen() && !queue.pending() {
 					mqttClient.client.Publish(topic, cfg.Qos, cfg.TelemetryRetain, b)
+					continue
+				}
+
+				// keep the order: the message is sent after the ones queued before
+				queue.push(topic, b)
+				if mqttClient.client.IsConnectionOpen() {
+					queue.flush(mqttClient)
 				}
 			}
 		}
@@ -0,0 +1,206 @@
+package mqttClient
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// telemetry messages are queued while the broker is unreachable and sent in order after the reconnect;
+// with a file configured, every queued message is appended to it such that the queue survives a restart
+
+// a flush is stopped if the broker does not acknowledge a message within this time
+const telemetryQueuePublishTimeout = 10 * time.Second
+
+// once the queue is full, size/telemetryQueueDropFraction of the oldest messages are dropped
+const telemetryQueueDropFraction = 10
+
+type queuedMessage struct {
+	Topic   string
+	Payload string
+}
+
+type telemetryQueue struct {
+	mutex    sync.Mutex
+	size     int
+	file     string
+	messages []queuedMessage
+
+	// the mutex is not held while publishing; a flush in progress publishes a copy of the messages and
+	// needs to know how many of them have been dropped by push meanwhile
+	flushing bool
+	dropped  int
+}
+
+func telemetryQueueCreate(size int, file string) (queue *telemetryQueue) {
+	queue = &telemetryQueue{
+		size:     size,
+		file:     file,
+		messages: make([]queuedMessage, 0),
+	}
+	queue.load()
+	return
+}
+
+func (queue *telemetryQueue) pending() bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return len(queue.messages) > 0
+}
+
+func (queue *telemetryQueue) push(topic string, payload []byte) {
+	if queue.size < 1 {
+		return
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	message := queuedMessage{Topic: topic, Payload: string(payload)}
+	queue.messages = append(queue.messages, message)
+
+	// drop the oldest messages if more than size messages are queued; a tenth of the queue is dropped at once
+	// such that the file is only rewritten every size/10 messages and a push is usually a single append
+	if overflow := len(queue.messages) - queue.size; overflow > 0 {
+		drop := overflow + queue.size/telemetryQueueDropFraction
+		log.Printf("mqttClient: telemetry queue full, drop %v messages", drop)
+		queue.messages = append(queue.messages[:0], queue.messages[drop:]...)
+		queue.dropped += drop
+		queue.save()
+		return
+	}
+
+	queue.append(message)
+}
+
+// flush publishes the queued messages in order; it stops at the first message which is not acknowledged.
+// The messages are published without holding the mutex such that push never waits for the broker.
+func (queue *telemetryQueue) flush(mqttClient *MqttClient) {
+	cfg := mqttClient.config
+
+	queue.mutex.Lock()
+	if queue.flushing || len(queue.messages) < 1 {
+		queue.mutex.Unlock()
+		return
+	}
+	queue.flushing = true
+	queue.dropped = 0
+	messages := make([]queuedMessage, len(queue.messages))
+	copy(messages, queue.messages)
+	queue.mutex.Unlock()
+
+	sent := 0
+	for _, message := range messages {
+		token := mqttClient.client.Publish(message.Topic, cfg.Qos, cfg.TelemetryRetain, []byte(message.Payload))
+		if !token.WaitTimeout(telemetryQueuePublishTimeout) || token.Error() != nil {
+			break
+		}
+		sent++
+	}
+
+	log.Printf("mqttClient: sent %v of %v queued telemetry messages", sent, len(messages))
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.flushing = false
+
+	// remove the sent prefix; messages dropped by push meanwhile have already been removed from the front
+	if remove := sent - queue.dropped; remove > 0 {
+		queue.messages = append(queue.messages[:0], queue.messages[remove:]...)
+		queue.save()
+	}
+}
+
+// load reads the messages queued before a restart
+func (queue *telemetryQueue) load() {
+	if len(queue.file) < 1 {
+		return
+	}
+
+	f, err := os.Open(queue.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("mqttClient: cannot read telemetry queue file=%v: %v", queue.file, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var message queuedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			// e.g. the last line written before a crash
+			continue
+		}
+		queue.messages = append(queue.messages, message)
+	}
+
+	if overflow := len(queue.messages) - queue.size; overflow > 0 {
+		// e.g. the size has been reduced; the file must match the queue for the following appends
+		queue.messages = queue.messages[overflow:]
+		queue.save()
+	}
+
+	log.Printf("mqttClient: loaded %v queued telemetry messages from %v", len(queue.messages), queue.file)
+}
+
+// append adds a single message to the file; the caller must hold the mutex
+func (queue *telemetryQueue) append(message queuedMessage) {
+	if len(queue.file) < 1 {
+		return
+	}
+
+	b, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(queue.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("mqttClient: cannot write telemetry queue file=%v: %v", queue.file, err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		log.Printf("mqttClient: cannot write telemetry queue file=%v: %v", queue.file, err)
+	}
+}
+
+// save replaces the file by the current queue; the caller must hold the mutex
+func (queue *telemetryQueue) save() {
+	if len(queue.file) < 1 {
+		return
+	}
+
+	if len(queue.messages) < 1 {
+		if err := os.Remove(queue.file); err != nil && !os.IsNotExist(err) {
+			log.Printf("mqttClient: cannot remove telemetry queue file=%v: %v", queue.file, err)
+		}
+		return
+	}
+
+	var b []byte
+	for _, message := range queue.messages {
+		line, err := json.Marshal(message)
+		if err != nil {
+			continue
+		}
+		b = append(append(b, line...), '\n')
+	}
+
+	tmpFile := queue.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, b, 0644); err != nil {
+		log.Printf("mqttClient: cannot write telemetry queue file=%v: %v", tmpFile, err)
+		return
+	}
+	if err := os.Rename(tmpFile, queue.file); err != nil {
+		log.Printf("mqttClient: cannot rename telemetry queue file=%v: %v", tmpFile, err)
+	}
+}
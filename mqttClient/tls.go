@@ -0,0 +1,51 @@
+package mqttClient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/config"
+	"io/ioutil"
+	"log"
+)
+
+func createTlsConfig(cfg *config.MqttClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if len(cfg.CaFile) > 0 {
+		b, err := ioutil.ReadFile(cfg.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CaFile: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.New("CaFile contains no certificates")
+		}
+	}
+
+	if len(cfg.CertFile) > 0 {
+		// fail early on a broken certificate, later changes are picked up on the next connect
+		if _, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %v", err)
+		}
+
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+			if err != nil {
+				log.Printf("mqttClient: cannot load client certificate: %v", err)
+				return nil, err
+			}
+			return &cert, nil
+		}
+	}
+
+	if cfg.InsecureSkipVerify {
+		log.Print("mqttClient: InsecureSkipVerify is set, the certificate of the broker is not verified")
+	}
+
+	return tlsConfig, nil
+}
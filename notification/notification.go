@@ -0,0 +1,135 @@
+package notification
+
+import (
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"log"
+	"net/http"
+	"time"
+)
+
+type Sink interface {
+	Send(message alarm.AlarmMessage) error
+}
+
+type sinkInstance struct {
+	config *config.NotificationConfig
+	sink   Sink
+
+	// time of the last notification per rule and device; used for rate limiting
+	lastSent map[string]time.Time
+	// rule and device of alarms whose raised message was rate limited; their later messages are suppressed as well
+	suppressed map[string]bool
+
+	input chan alarm.AlarmMessage
+}
+
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+func SinkCreate(cfg *config.NotificationConfig) (sink Sink) {
+	switch cfg.Type {
+	case "Webhook":
+		return WebhookSinkCreate(cfg)
+	case "Smtp":
+		return SmtpSinkCreate(cfg)
+	case "Gotify":
+		return GotifySinkCreate(cfg)
+	case "Ntfy":
+		return NtfySinkCreate(cfg)
+	}
+	return nil
+}
+
+func Run(configs []*config.NotificationConfig, alarmEngine *alarm.Engine) {
+	instances := make([]*sinkInstance, 0, len(configs))
+
+	for _, cfg := range configs {
+		sink := SinkCreate(cfg)
+		if sink == nil {
+			log.Printf("notification: unknown type=%v of name=%v", cfg.Type, cfg.Name)
+			continue
+		}
+
+		log.Printf("notification: setup name=%v type=%v", cfg.Name, cfg.Type)
+
+		instance := &sinkInstance{
+			config:     cfg,
+			sink:       sink,
+			lastSent:   make(map[string]time.Time),
+			suppressed: make(map[string]bool),
+			// buffered such that a slow sink does not block the alarm engine
+			input: make(chan alarm.AlarmMessage, 16),
+		}
+		go instance.mainSinkRoutine()
+
+		instances = append(instances, instance)
+	}
+
+	// fan-out every alarm event to all sinks
+	alarmChan := alarmEngine.Subscribe()
+	go func() {
+		for a := range alarmChan {
+			message := a.ConvertToMessage()
+			for _, instance := range instances {
+				if !instance.routes(message) {
+					continue
+				}
+
+				select {
+				case instance.input <- message:
+				default:
+					log.Printf("notification: queue of name=%v is full, drop message", instance.config.Name)
+				}
+			}
+		}
+	}()
+}
+
+func (instance *sinkInstance) mainSinkRoutine() {
+	for message := range instance.input {
+		key := message.RuleName + "/" + message.DeviceName
+
+		// only raised alarms are rate limited; the other messages are sent if and only if the raise was sent
+		if message.State == alarm.StateRaised {
+			if last, ok := instance.lastSent[key]; ok && time.Since(last) < instance.config.MinInterval {
+				log.Printf(
+					"notification: rate limit name=%v rule=%v device=%v",
+					instance.config.Name, message.RuleName, message.DeviceName,
+				)
+				instance.suppressed[key] = true
+				continue
+			}
+			instance.lastSent[key] = time.Now()
+			delete(instance.suppressed, key)
+		} else if instance.suppressed[key] {
+			if message.State == alarm.StateCleared {
+				delete(instance.suppressed, key)
+			}
+			continue
+		}
+
+		if err := instance.sink.Send(message); err != nil {
+			log.Printf("notification: send failed name=%v err=%v", instance.config.Name, err)
+		}
+	}
+}
+
+// routes checks whether the message matches the rule and state lists of the sink
+func (instance *sinkInstance) routes(message alarm.AlarmMessage) bool {
+	return listContains(instance.config.Rules, message.RuleName, true) &&
+		listContains(instance.config.States, string(message.State), false)
+}
+
+func listContains(list []string, item string, emptyMatchesAll bool) bool {
+	if len(list) < 1 {
+		return emptyMatchesAll
+	}
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
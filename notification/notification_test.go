@@ -0,0 +1,166 @@
+package notification
+
+import (
+	"encoding/json"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubServer records the bodies of all requests
+type stubServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	status   int
+}
+
+func stubServerCreate(t *testing.T) *stubServer {
+	stub := &stubServer{status: http.StatusOK}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		buffer := make([]byte, 4096)
+		for {
+			n, err := r.Body.Read(buffer)
+			body = append(body, buffer[:n]...)
+			if err != nil {
+				break
+			}
+		}
+
+		stub.mutex.Lock()
+		stub.requests = append(stub.requests, r)
+		stub.bodies = append(stub.bodies, body)
+		status := stub.status
+		stub.mutex.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (stub *stubServer) received() (requests []*http.Request, bodies [][]byte) {
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	return stub.requests, stub.bodies
+}
+
+func testMessage(state alarm.State) alarm.AlarmMessage {
+	return alarm.AlarmMessage{
+		RuleName:   "LowVoltage",
+		DeviceName: "24v-bmv",
+		ValueName:  "MainVoltage",
+		State:      state,
+		Value:      22.5,
+		Unit:       "V",
+		Message:    "voltage below 23V",
+		Raised:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+// runSink sends the messages through the routine of a webhook sink and returns the states posted to the stub
+func runSink(t *testing.T, minInterval time.Duration, messages ...alarm.AlarmMessage) []alarm.State {
+	stub := stubServerCreate(t)
+	cfg := &config.NotificationConfig{Name: "test", Type: "Webhook", Url: stub.URL, MinInterval: minInterval}
+
+	instance := &sinkInstance{
+		config:     cfg,
+		sink:       WebhookSinkCreate(cfg),
+		lastSent:   make(map[string]time.Time),
+		suppressed: make(map[string]bool),
+		input:      make(chan alarm.AlarmMessage, len(messages)),
+	}
+	for _, message := range messages {
+		instance.input <- message
+	}
+	close(instance.input)
+	instance.mainSinkRoutine()
+
+	_, bodies := stub.received()
+	states := make([]alarm.State, 0, len(bodies))
+	for _, body := range bodies {
+		var message alarm.AlarmMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Fatalf("invalid body=%s: %v", body, err)
+		}
+		states = append(states, message.State)
+	}
+	return states
+}
+
+func checkStates(t *testing.T, got []alarm.State, expected ...alarm.State) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("expected states %v, got %v", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("expected states %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	states := runSink(t, time.Hour,
+		testMessage(alarm.StateRaised),
+		testMessage(alarm.StateCleared),
+		testMessage(alarm.StateRaised),
+		testMessage(alarm.StateRaised),
+	)
+	checkStates(t, states, alarm.StateRaised, alarm.StateCleared)
+}
+
+func TestRateLimitSuppressesCleared(t *testing.T) {
+	states := runSink(t, time.Hour,
+		testMessage(alarm.StateRaised),
+		testMessage(alarm.StateCleared),
+		// rate limited, hence its acknowledge and clear must not be sent either
+		testMessage(alarm.StateRaised),
+		testMessage(alarm.StateAcknowledged),
+		testMessage(alarm.StateCleared),
+	)
+	checkStates(t, states, alarm.StateRaised, alarm.StateCleared)
+}
+
+func TestRateLimitPerDevice(t *testing.T) {
+	other := testMessage(alarm.StateRaised)
+	other.DeviceName = "12v-bmv"
+
+	states := runSink(t, time.Hour, testMessage(alarm.StateRaised), other)
+	checkStates(t, states, alarm.StateRaised, alarm.StateRaised)
+}
+
+func TestNoRateLimit(t *testing.T) {
+	states := runSink(t, 0,
+		testMessage(alarm.StateRaised),
+		testMessage(alarm.StateCleared),
+		testMessage(alarm.StateRaised),
+		testMessage(alarm.StateCleared),
+	)
+	checkStates(t, states, alarm.StateRaised, alarm.StateCleared, alarm.StateRaised, alarm.StateCleared)
+}
+
+func TestRoutes(t *testing.T) {
+	instance := &sinkInstance{config: &config.NotificationConfig{
+		Rules:  []string{"LowVoltage"},
+		States: []string{"Raised"},
+	}}
+
+	if !instance.routes(testMessage(alarm.StateRaised)) {
+		t.Error("raised message of configured rule not routed")
+	}
+	if instance.routes(testMessage(alarm.StateCleared)) {
+		t.Error("cleared message routed although only raised is configured")
+	}
+	other := testMessage(alarm.StateRaised)
+	other.RuleName = "HighTemperature"
+	if instance.routes(other) {
+		t.Error("message of other rule routed")
+	}
+}
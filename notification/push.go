@@ -0,0 +1,112 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GotifySink sends messages to a gotify server using its /message endpoint
+type GotifySink struct {
+	url      string
+	token    string
+	priority int
+}
+
+// NtfySink publishes messages to a ntfy topic url
+type NtfySink struct {
+	url      string
+	token    string
+	priority int
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+func GotifySinkCreate(cfg *config.NotificationConfig) *GotifySink {
+	return &GotifySink{
+		url:      strings.TrimRight(cfg.Url, "/") + "/message",
+		token:    cfg.Token,
+		priority: cfg.Priority,
+	}
+}
+
+func (sink *GotifySink) Send(message alarm.AlarmMessage) error {
+	b, err := json.Marshal(gotifyMessage{
+		Title:    subject(message),
+		Message:  message.Message,
+		Priority: sink.priority,
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", sink.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	if len(sink.token) > 0 {
+		request.Header.Set("X-Gotify-Key", sink.token)
+	}
+
+	return doPushRequest(request)
+}
+
+func NtfySinkCreate(cfg *config.NotificationConfig) *NtfySink {
+	// ntfy uses priorities from 1 (min) to 5 (max); 0 uses the server default
+	priority := cfg.Priority
+	if priority < 0 {
+		priority = 0
+	} else if priority > 5 {
+		priority = 5
+	}
+
+	return &NtfySink{
+		url:      cfg.Url,
+		token:    cfg.Token,
+		priority: priority,
+	}
+}
+
+func (sink *NtfySink) Send(message alarm.AlarmMessage) error {
+	request, err := http.NewRequest("POST", sink.url, strings.NewReader(message.Message))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Title", subject(message))
+	if sink.priority > 0 {
+		request.Header.Set("Priority", strconv.Itoa(sink.priority))
+	}
+	if message.State == alarm.StateCleared {
+		request.Header.Set("Tags", "white_check_mark")
+	} else {
+		request.Header.Set("Tags", "warning")
+	}
+	if len(sink.token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+sink.token)
+	}
+
+	return doPushRequest(request)
+}
+
+func doPushRequest(request *http.Request) error {
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("push server returned status=%v", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package notification
+
+import (
+	"encoding/json"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"testing"
+)
+
+func TestGotify(t *testing.T) {
+	stub := stubServerCreate(t)
+	sink := GotifySinkCreate(&config.NotificationConfig{Url: stub.URL + "/", Token: "app-token", Priority: 8})
+
+	if err := sink.Send(testMessage(alarm.StateRaised)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, bodies := stub.received()
+	if requests[0].URL.Path != "/message" {
+		t.Errorf("unexpected path=%v", requests[0].URL.Path)
+	}
+	if key := requests[0].Header.Get("X-Gotify-Key"); key != "app-token" {
+		t.Errorf("unexpected X-Gotify-Key=%v", key)
+	}
+
+	var message gotifyMessage
+	if err := json.Unmarshal(bodies[0], &message); err != nil {
+		t.Fatal(err)
+	}
+	expected := gotifyMessage{
+		Title:    "[go-ve-sensor] Raised: voltage below 23V",
+		Message:  "voltage below 23V",
+		Priority: 8,
+	}
+	if message != expected {
+		t.Errorf("expected %+v, got %+v", expected, message)
+	}
+}
+
+func TestGotifyWithoutToken(t *testing.T) {
+	stub := stubServerCreate(t)
+	sink := GotifySinkCreate(&config.NotificationConfig{Url: stub.URL})
+
+	if err := sink.Send(testMessage(alarm.StateRaised)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, _ := stub.received()
+	if _, ok := requests[0].Header["X-Gotify-Key"]; ok {
+		t.Error("X-Gotify-Key sent without a configured token")
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	stub := stubServerCreate(t)
+	sink := NtfySinkCreate(&config.NotificationConfig{Url: stub.URL + "/alarms", Token: "tk_secret", Priority: 9})
+
+	if err := sink.Send(testMessage(alarm.StateCleared)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, bodies := stub.received()
+	header := requests[0].Header
+	for name, expected := range map[string]string{
+		"Title":         "[go-ve-sensor] Cleared: voltage below 23V",
+		"Priority":      "5",
+		"Tags":          "white_check_mark",
+		"Authorization": "Bearer tk_secret",
+	} {
+		if value := header.Get(name); value != expected {
+			t.Errorf("expected %v=%v, got %v", name, expected, value)
+		}
+	}
+	if requests[0].URL.Path != "/alarms" {
+		t.Errorf("unexpected path=%v", requests[0].URL.Path)
+	}
+	if string(bodies[0]) != "voltage below 23V" {
+		t.Errorf("unexpected body=%s", bodies[0])
+	}
+}
+
+func TestNtfyWithoutToken(t *testing.T) {
+	stub := stubServerCreate(t)
+	sink := NtfySinkCreate(&config.NotificationConfig{Url: stub.URL})
+
+	if err := sink.Send(testMessage(alarm.StateRaised)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, _ := stub.received()
+	if _, ok := requests[0].Header["Authorization"]; ok {
+		t.Error("Authorization sent without a configured token")
+	}
+	if _, ok := requests[0].Header["Priority"]; ok {
+		t.Error("Priority sent without a configured priority")
+	}
+}
@@ -0,0 +1,55 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SmtpSink struct {
+	address string
+	auth    smtp.Auth
+	from    string
+	to      []string
+}
+
+func SmtpSinkCreate(cfg *config.NotificationConfig) *SmtpSink {
+	sink := &SmtpSink{
+		address: cfg.SmtpHost + ":" + strconv.Itoa(cfg.SmtpPort),
+		from:    cfg.From,
+		to:      cfg.To,
+	}
+
+	if len(cfg.SmtpUser) > 0 {
+		sink.auth = smtp.PlainAuth("", cfg.SmtpUser, cfg.SmtpPassword, cfg.SmtpHost)
+	}
+
+	return sink
+}
+
+func (sink *SmtpSink) Send(message alarm.AlarmMessage) error {
+	body := new(bytes.Buffer)
+	fmt.Fprintf(body, "From: %s\r\n", sink.from)
+	fmt.Fprintf(body, "To: %s\r\n", strings.Join(sink.to, ", "))
+	fmt.Fprintf(body, "Subject: %s\r\n", subject(message))
+	fmt.Fprintf(body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(body, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(body, "\r\n")
+	fmt.Fprintf(body, "%s\r\n\r\n", message.Message)
+	fmt.Fprintf(body, "Rule:   %s\r\n", message.RuleName)
+	fmt.Fprintf(body, "Device: %s\r\n", message.DeviceName)
+	fmt.Fprintf(body, "Value:  %s = %v%s\r\n", message.ValueName, message.Value, message.Unit)
+	fmt.Fprintf(body, "State:  %s\r\n", message.State)
+	fmt.Fprintf(body, "Raised: %s\r\n", message.Raised.Format(time.RFC3339))
+
+	return smtp.SendMail(sink.address, sink.auth, sink.from, sink.to, body.Bytes())
+}
+
+func subject(message alarm.AlarmMessage) string {
+	return fmt.Sprintf("[go-ve-sensor] %s: %s", message.State, message.Message)
+}
@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"bufio"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// smtpStub accepts a single mail without authentication and returns the commands and the data it received
+func smtpStub(t *testing.T) (port int, result <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var received []string
+		reader := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost stub")
+		data := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			received = append(received, line)
+
+			if data {
+				if line == "." {
+					data = false
+					reply("250 OK")
+				}
+				continue
+			}
+
+			switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				data = true
+				reply("354 go ahead")
+			case "QUIT":
+				reply("221 bye")
+				lines <- received
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+		lines <- received
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, lines
+}
+
+func TestSmtp(t *testing.T) {
+	port, result := smtpStub(t)
+	sink := SmtpSinkCreate(&config.NotificationConfig{
+		SmtpHost: "127.0.0.1",
+		SmtpPort: port,
+		From:     "sensor@example.com",
+		To:       []string{"a@example.com", "b@example.com"},
+	})
+
+	if err := sink.Send(testMessage(alarm.StateRaised)); err != nil {
+		t.Fatal(err)
+	}
+
+	received := strings.Join(<-result, "\n")
+	for _, expected := range []string{
+		"MAIL FROM:<sensor@example.com>",
+		"RCPT TO:<a@example.com>",
+		"RCPT TO:<b@example.com>",
+		"To: a@example.com, b@example.com",
+		"Subject: [go-ve-sensor] Raised: voltage below 23V",
+		"Device: 24v-bmv",
+		"Value:  MainVoltage = 22.5V",
+		"Raised: 2020-01-02T03:04:05Z",
+	} {
+		if !strings.Contains(received, expected) {
+			t.Errorf("expected %q in:\n%v", expected, received)
+		}
+	}
+}
+
+func TestSmtpUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	sink := SmtpSinkCreate(&config.NotificationConfig{SmtpHost: "127.0.0.1", SmtpPort: port, From: "a@b", To: []string{"c@d"}})
+	if err := sink.Send(testMessage(alarm.StateRaised)); err == nil {
+		t.Error("expected an error, port " + strconv.Itoa(port) + " is closed")
+	}
+}
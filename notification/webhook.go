@@ -0,0 +1,69 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"log"
+	"text/template"
+)
+
+type WebhookSink struct {
+	url      string
+	template *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes any value as json, e.g. {"text": {{json .Message}}}
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func WebhookSinkCreate(cfg *config.NotificationConfig) *WebhookSink {
+	sink := &WebhookSink{
+		url: cfg.Url,
+	}
+
+	if len(cfg.BodyTemplate) > 0 {
+		t, err := template.New(cfg.Name).Funcs(templateFuncs).Parse(cfg.BodyTemplate)
+		if err != nil {
+			log.Fatalf("notification: cannot parse BodyTemplate of name=%v: %v", cfg.Name, err)
+		}
+		sink.template = t
+	}
+
+	return sink
+}
+
+func (sink *WebhookSink) Send(message alarm.AlarmMessage) error {
+	var body []byte
+
+	if sink.template == nil {
+		b, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		body = b
+	} else {
+		buffer := new(bytes.Buffer)
+		if err := sink.template.Execute(buffer, message); err != nil {
+			return err
+		}
+		body = buffer.Bytes()
+	}
+
+	response, err := httpClient.Post(sink.url, "application/json; charset=UTF-8", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status=%v", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package notification
+
+import (
+	"encoding/json"
+	"github.com/koestler/go-ve-sensor/alarm"
+	"github.com/koestler/go-ve-sensor/config"
+	"net/http"
+	"testing"
+)
+
+func TestWebhookDefaultPayload(t *testing.T) {
+	stub := stubServerCreate(t)
+	sink := WebhookSinkCreate(&config.NotificationConfig{Name: "test", Url: stub.URL})
+
+	if err := sink.Send(testMessage(alarm.StateRaised)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, bodies := stub.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %v", len(requests))
+	}
+	if requests[0].Method != "POST" {
+		t.Errorf("expected POST, got %v", requests[0].Method)
+	}
+	if ct := requests[0].Header.Get("Content-Type"); ct != "application/json; charset=UTF-8" {
+		t.Errorf("unexpected Content-Type=%v", ct)
+	}
+
+	var message alarm.AlarmMessage
+	if err := json.Unmarshal(bodies[0], &message); err != nil {
+		t.Fatal(err)
+	}
+	if message != testMessage(alarm.StateRaised) {
+		t.Errorf("unexpected payload %+v", message)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	stub := stubServerCreate(t)
+	sink := WebhookSinkCreate(&config.NotificationConfig{
+		Name:         "test",
+		Url:          stub.URL,
+		BodyTemplate: `{"text": {{json .Message}}, "state": "{{.State}}"}`,
+	})
+
+	if err := sink.Send(testMessage(alarm.StateCleared)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, bodies := stub.received()
+	expected := `{"text": "voltage below 23V", "state": "Cleared"}`
+	if string(bodies[0]) != expected {
+		t.Errorf("expected body=%v, got %s", expected, bodies[0])
+	}
+}
+
+func TestWebhookErrorStatus(t *testing.T) {
+	stub := stubServerCreate(t)
+	stub.status = http.StatusInternalServerError
+	sink := WebhookSinkCreate(&config.NotificationConfig{Name: "test", Url: stub.URL})
+
+	if err := sink.Send(testMessage(alarm.StateRaised)); err == nil {
+		t.Error("expected an error for status 500")
+	}
+}
@@ -0,0 +1,226 @@
+package vedevices
+
+import (
+	"errors"
+	"fmt"
+	"github.com/koestler/go-ve-sensor/storage"
+	"github.com/koestler/go-ve-sensor/vedirect"
+	"math"
+	"sync"
+	"time"
+)
+
+// WritableRegister is a register which can be set by a command
+type WritableRegister struct {
+	Register
+	// number of bytes of the register
+	Size int
+	// values outside this range are rejected before they are sent to the device
+	Min float64
+	Max float64
+	// Min and Max are given for a 12V system and scaled to the battery voltage of the charger
+	PerBatteryVoltage bool
+}
+
+type WritableRegisters map[string]WritableRegister
+
+var WritableRegisterListBmv = WritableRegisters{
+	// only effective when the relay mode is set to remote
+	"RelayControl": WritableRegister{
+		Register: Register{Address: 0x034E, Factor: 1, Unit: ""},
+		Size:     1,
+		Min:      0,
+		Max:      1,
+	},
+}
+
+var WritableRegisterListSolar = WritableRegisters{
+	"BatteryAbsorptionVoltage": WritableRegister{
+		Register:          RegisterListSolar["BatteryAbsorptionVoltage"],
+		Size:              2,
+		Min:               12,
+		Max:               16,
+		PerBatteryVoltage: true,
+	},
+	"BatteryFloatVoltage": WritableRegister{
+		Register:          RegisterListSolar["BatteryFloatVoltage"],
+		Size:              2,
+		Min:               12,
+		Max:               16,
+		PerBatteryVoltage: true,
+	},
+	// the blueSolarMppt75_15 charges with at most 15A
+	"BatteryMaximumCurrent": WritableRegister{
+		Register: RegisterListSolar["BatteryMaximumCurrent"],
+		Size:     2,
+		Min:      0,
+		Max:      15,
+	},
+	// 0: off, 1: automatic, 2: alternative 1, 3: alternative 2, 4: on, 5: user defined 1, 6: user defined 2
+	"LoadOutputControl": WritableRegister{
+		Register: Register{Address: 0xEDAB, Factor: 1, Unit: ""},
+		Size:     1,
+		Min:      0,
+		Max:      6,
+	},
+}
+
+func WritableRegisterFactoryByModel(model string) WritableRegisters {
+	switch model {
+	case "bmv700", "bmv702":
+		return WritableRegisterListBmv
+	case "blueSolarMppt75_15":
+		return WritableRegisterListSolar
+	}
+	return WritableRegisters{}
+}
+
+// the battery voltage the charger is configured for: 12, 24, 36 or 48
+var batteryVoltageRegister = Register{Address: 0xEDEF, Factor: 1, Unit: "V"}
+
+// needsBatteryVoltage returns true if the bounds of some registers depend on the battery voltage
+func (writable WritableRegisters) needsBatteryVoltage() bool {
+	for _, register := range writable {
+		if register.PerBatteryVoltage {
+			return true
+		}
+	}
+	return false
+}
+
+// forBatteryVoltage scales the bounds of the PerBatteryVoltage registers to the given battery voltage;
+// the registers are not writable if the battery voltage is unknown (0)
+func (writable WritableRegisters) forBatteryVoltage(batteryVoltage float64) WritableRegisters {
+	scaled := make(WritableRegisters, len(writable))
+	for name, register := range writable {
+		if register, err := register.forBatteryVoltage(batteryVoltage); err == nil {
+			scaled[name] = register
+		}
+	}
+	return scaled
+}
+
+var errBatteryVoltageUnknown = errors.New("battery voltage is unknown")
+
+// forBatteryVoltage scales the bounds of a PerBatteryVoltage register to the given battery voltage
+func (register WritableRegister) forBatteryVoltage(batteryVoltage float64) (WritableRegister, error) {
+	if !register.PerBatteryVoltage {
+		return register, nil
+	}
+	if batteryVoltage <= 0 {
+		return register, errBatteryVoltageUnknown
+	}
+	register.Min *= batteryVoltage / 12
+	register.Max *= batteryVoltage / 12
+	register.PerBatteryVoltage = false
+	return register, nil
+}
+
+// checkRange rejects values outside the bounds of the register
+func (register WritableRegister) checkRange(value float64) error {
+	if value < register.Min || value > register.Max {
+		return fmt.Errorf("value %v out of range [%v, %v]", value, register.Min, register.Max)
+	}
+	return nil
+}
+
+type CommandType int
+
+const (
+	// write the value to the register of the given name
+	CommandSet CommandType = iota
+	// read all registers immediately
+	CommandReread
+	// restart the device
+	CommandRestart
+)
+
+func (commandType CommandType) String() string {
+	switch commandType {
+	case CommandSet:
+		return "Set"
+	case CommandReread:
+		return "Reread"
+	case CommandRestart:
+		return "Restart"
+	}
+	return ""
+}
+
+type Command struct {
+	Type  CommandType
+	Name  string
+	Value float64
+
+	result chan error
+}
+
+// a command is rejected if the source does not accept it within this time, e.g. since the device is gone
+const commandTimeout = 30 * time.Second
+
+var commandChannelsMutex sync.RWMutex
+var commandChannels = make(map[*storage.Device]commandChannel)
+
+type commandChannel struct {
+	input    chan Command
+	writable WritableRegisters
+}
+
+var ErrCommandsNotSupported = errors.New("device does not accept commands")
+
+// registerCommands is called by the sources; the returned channel must be read by the source routine.
+// The bounds of PerBatteryVoltage registers are checked by the source since only it knows the battery voltage.
+func registerCommands(device *storage.Device, writable WritableRegisters) <-chan Command {
+	commandChannelsMutex.Lock()
+	defer commandChannelsMutex.Unlock()
+
+	input := make(chan Command)
+	commandChannels[device] = commandChannel{input: input, writable: writable}
+	return input
+}
+
+// SendCommand validates the command, passes it to the source of the device and returns its result
+func SendCommand(device *storage.Device, command Command) error {
+	commandChannelsMutex.RLock()
+	channel, ok := commandChannels[device]
+	commandChannelsMutex.RUnlock()
+	if !ok {
+		return ErrCommandsNotSupported
+	}
+
+	if command.Type == CommandSet {
+		register, ok := channel.writable[command.Name]
+		if !ok {
+			return fmt.Errorf("register %v is not writable", command.Name)
+		}
+		if !register.PerBatteryVoltage {
+			if err := register.checkRange(command.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	command.result = make(chan error, 1)
+
+	select {
+	case channel.input <- command:
+	case <-time.After(commandTimeout):
+		return errors.New("device did not accept the command in time")
+	}
+
+	return <-command.result
+}
+
+// encode converts the value to the little endian raw value of the register
+func (register WritableRegister) encode(value float64) []byte {
+	raw := int64(math.Round(value / register.Factor))
+	b := make([]byte, register.Size)
+	for i := range b {
+		b[i] = byte(raw >> uint(i*8))
+	}
+	return b
+}
+
+func (register WritableRegister) send(vd *vedirect.Vedirect, value float64) error {
+	return vd.VeCommandSet(register.Address, register.encode(value))
+}
@@ -0,0 +1,11 @@
+package vedevices
+
+import (
+	"github.com/koestler/go-ve-sensor/metrics"
+)
+
+var droppedValues = metrics.CounterVecCreate(
+	"vesensor_vedevices_dropped_values_total",
+	"Number of register values which could not be read from the device.",
+	"device",
+)
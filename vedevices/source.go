@@ -19,17 +19,40 @@ func CreateDummySource(device *storage.Device, config *config.VedeviceConfig) (*
 	// setup output chain
 	output := make(chan dataflow.Value)
 
+	// commands are accepted for the writable registers of the model; set values are sent instead of random ones
+	// the dummy simulates a 12V system
+	commands := registerCommands(device, WritableRegisterFactoryByModel(config.Model).forBatteryVoltage(12))
+	overrides := make(map[string]float64)
+
 	// start source go routine
 	go func() {
 		defer close(output)
-		for _ = range time.Tick(time.Second) {
-			for name, register := range registers {
-				output <- dataflow.Value{
-					Device:        device,
-					Name:          name,
-					Value:         1e2 * rand.Float64() * register.Factor,
-					Unit:          register.Unit,
-					RoundDecimals: register.RoundDecimals,
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+
+		for {
+			select {
+			case command := <-commands:
+				switch command.Type {
+				case CommandSet:
+					overrides[command.Name] = command.Value
+				case CommandRestart:
+					overrides = make(map[string]float64)
+				}
+				command.result <- nil
+			case <-tick.C:
+				for name, register := range registers {
+					value := 1e2 * rand.Float64() * register.Factor
+					if override, ok := overrides[name]; ok {
+						value = override
+					}
+					output <- dataflow.Value{
+						Device:        device,
+						Name:          name,
+						Value:         value,
+						Unit:          register.Unit,
+						RoundDecimals: register.RoundDecimals,
+					}
 				}
 			}
 		}
@@ -78,31 +101,91 @@ func CreateSource(device *storage.Device, config *config.VedeviceConfig) (err er
 	// setup output chain with enough space to hold some values
 	output := make(chan dataflow.Value, len(registers)/4)
 
+	// commands are executed by the reader routine since it is the only user of the serial port
+	writable := WritableRegisterFactoryByModel(config.Model)
+	commands := registerCommands(device, writable)
+
+	// the bounds of the voltage registers depend on the battery voltage; if it cannot be read now,
+	// it is read again when a command for such a register arrives
+	batteryVoltage := 0.0
+	readBatteryVoltage := func() {
+		if numericValue, err := batteryVoltageRegister.RecvNumeric(vd); err != nil {
+			log.Printf("vedevices source: cannot read battery voltage: %v", err)
+		} else {
+			batteryVoltage = numericValue.Value
+			log.Printf("vedevices source: battery voltage=%vV", batteryVoltage)
+		}
+	}
+	if writable.needsBatteryVoltage() {
+		readBatteryVoltage()
+	}
+
 	// start vedevices reader
 	go func() {
 		defer close(output)
 		// flush buffer
 		vd.RecvFlush()
 
-		for _ = range time.Tick(100*time.Millisecond) {
-			if err := vd.VeCommandPing(); err != nil {
-				log.Printf("vedevices source: VeCommandPing failed: %v", err)
-				continue
+		poll := func(name string, register Register) {
+			if numericValue, err := register.RecvNumeric(vd); err != nil {
+				log.Printf(
+					"device: vedevices.RecvNumeric failed device=%v nameName=%v err=%v", device.Name, name, err,
+				)
+				droppedValues.Inc(device.Name)
+			} else {
+				output <- dataflow.Value{
+					Device:        device,
+					Name:          name,
+					Value:         numericValue.Value,
+					Unit:          numericValue.Unit,
+					RoundDecimals: register.RoundDecimals,
+				}
 			}
+		}
 
-			for name, register := range registers {
-				if numericValue, err := register.RecvNumeric(vd); err != nil {
-					log.Printf(
-						"device: vedevices.RecvNumeric failed device=%v nameName=%v err=%v", device.Name, name, err,
-					)
-				} else {
-					output <- dataflow.Value{
-						Device:        device,
-						Name:          name,
-						Value:         numericValue.Value,
-						Unit:          numericValue.Unit,
-						RoundDecimals: register.RoundDecimals,
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+
+		for {
+			select {
+			case command := <-commands:
+				log.Printf("vedevices source: execute command device=%v type=%v name=%v value=%v",
+					device.Name, command.Type, command.Name, command.Value)
+				var err error
+				switch command.Type {
+				case CommandSet:
+					register := writable[command.Name]
+					if register.PerBatteryVoltage && batteryVoltage <= 0 {
+						readBatteryVoltage()
+					}
+					if register, err = register.forBatteryVoltage(batteryVoltage); err != nil {
+						break
+					}
+					if err = register.checkRange(command.Value); err != nil {
+						break
+					}
+					if err = register.send(vd, command.Value); err == nil {
+						// publish the new value immediately if the register is also read
+						if register, ok := registers[command.Name]; ok {
+							poll(command.Name, register)
+						}
 					}
+				case CommandReread:
+					for name, register := range registers {
+						poll(name, register)
+					}
+				case CommandRestart:
+					err = vd.VeCommandRestart()
+				}
+				command.result <- err
+			case <-tick.C:
+				if err := vd.VeCommandPing(); err != nil {
+					log.Printf("vedevices source: VeCommandPing failed: %v", err)
+					continue
+				}
+
+				for name, register := range registers {
+					poll(name, register)
 				}
 			}
 		}
@@ -12,6 +12,26 @@ const (
 	VeCommandAsync      VeCommand = 0x0A
 )
 
+func (command VeCommand) String() string {
+	switch command {
+	case VeCommandPing:
+		return "Ping"
+	case VeCommandAppVersion:
+		return "AppVersion"
+	case VeCommandDeviceId:
+		return "DeviceId"
+	case VeCommandRestart:
+		return "Restart"
+	case VeCommandGet:
+		return "Get"
+	case VeCommandSet:
+		return "Set"
+	case VeCommandAsync:
+		return "Async"
+	}
+	return "Unknown"
+}
+
 type VeResponse byte
 
 const (
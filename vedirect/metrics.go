@@ -0,0 +1,34 @@
+package vedirect
+
+import (
+	"github.com/koestler/go-ve-sensor/metrics"
+	"time"
+)
+
+var commandDuration = metrics.SummaryCreate(
+	"vesensor_vedirect_command_duration_seconds",
+	"Time from sending a command until its response has been received.",
+	"port", "command",
+)
+
+var commandGetRetries = metrics.CounterVecCreate(
+	"vesensor_vedirect_command_get_retries_total",
+	"Number of failed tries of a get command which were retried.",
+	"port",
+)
+
+var commandGetFailures = metrics.CounterVecCreate(
+	"vesensor_vedirect_command_get_failures_total",
+	"Number of get commands which failed after all tries.",
+	"port",
+)
+
+var checksumErrors = metrics.CounterVecCreate(
+	"vesensor_vedirect_checksum_errors_total",
+	"Number of responses with an invalid checksum.",
+	"port",
+)
+
+func observeCommandDuration(vd *Vedirect, command VeCommand, start time.Time) {
+	commandDuration.Observe(time.Since(start).Seconds(), vd.portName, command.String())
+}
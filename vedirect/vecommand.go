@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 )
 
 func computeChecksum(cmd byte, data []byte) (checksum byte) {
@@ -33,6 +34,7 @@ func (vd *Vedirect) SendVeCommand(cmd VeCommand, data []byte) (err error) {
 
 func (vd *Vedirect) VeCommandPing() (err error) {
 	debugPrintf("vedirect: VeCommandPing begin")
+	defer observeCommandDuration(vd, VeCommandPing, time.Now())
 
 	err = vd.SendVeCommand(VeCommandPing, []byte{})
 	if err != nil {
@@ -67,12 +69,19 @@ func (vd *Vedirect) VeCommandDeviceId() (deviceId VeProduct, err error) {
 }
 
 func (vd *Vedirect) VeCommand(command VeCommand, address uint16) (values []byte, err error) {
+	return vd.veCommand(command, address, nil)
+}
+
+// veCommand sends the command; for get and set the address and flags are sent followed by data
+func (vd *Vedirect) veCommand(command VeCommand, address uint16, data []byte) (values []byte, err error) {
 	debugPrintf("vedirect: VeCommand begin command=%v, address=%x", command, address)
+	defer observeCommandDuration(vd, command, time.Now())
 
 	var param []byte
 	if command == VeCommandGet || command == VeCommandSet {
 		id := []byte{byte(address), byte(address >> 8)}
 		param = append(id, 0x00)
+		param = append(param, data...)
 	}
 
 	err = vd.SendVeCommand(command, param)
@@ -135,6 +144,7 @@ func (vd *Vedirect) VeCommand(command VeCommand, address uint16) (values []byte,
 
 	checksum := computeChecksum(byte(response), values)
 	if checksum != responseChecksum {
+		checksumErrors.Inc(vd.portName)
 		err = errors.New(fmt.Sprintf("checksum != responseChecksum, checksum=%X, responseChecksum=%X", checksum, responseChecksum))
 		debugPrintf("vedirect: VeCommand end err=%v", err)
 		return nil, err
@@ -200,6 +210,7 @@ func (vd *Vedirect) VeCommandGet(address uint16) (value []byte, err error) {
 		rawValues, err = vd.VeCommand(VeCommandGet, address)
 		if err != nil {
 			log.Printf("vedirect: VeCommandGet retry try=%v err=%v", try, err)
+			commandGetRetries.Inc(vd.portName)
 			continue
 		}
 
@@ -208,6 +219,7 @@ func (vd *Vedirect) VeCommandGet(address uint16) (value []byte, err error) {
 		if address != responseAddress {
 			err = errors.New(fmt.Sprintf("address != responseAddress, address=%x, responseAddress=%x", address, responseAddress))
 			log.Printf("vedirect: VeCommandGet retry try=%v err=%v", try, err)
+			commandGetRetries.Inc(vd.portName)
 			continue
 		}
 
@@ -216,6 +228,7 @@ func (vd *Vedirect) VeCommandGet(address uint16) (value []byte, err error) {
 		if VeResponseFlagOk != responseFlag {
 			err = errors.New(fmt.Sprintf("VeResponseFlagOk != responseFlag, responseFlag=%v", responseFlag))
 			log.Printf("vedirect: VeCommandGet retry try=%v err=%v", try, err)
+			commandGetRetries.Inc(vd.portName)
 			continue
 		}
 
@@ -225,6 +238,7 @@ func (vd *Vedirect) VeCommandGet(address uint16) (value []byte, err error) {
 	}
 
 	debugPrintf("vedirect: VeCommandGet end tries=%v last err=%v")
+	commandGetFailures.Inc(vd.portName)
 	err = errors.New(fmt.Sprintf("gave up after %v tries, last err=%v", numbTries, err))
 	return nil, err
 }
@@ -257,6 +271,58 @@ func (vd *Vedirect) VeCommandGetInt(address uint16) (value int64, err error) {
 	return
 }
 
+// VeCommandSet writes the little endian encoded value to the register at address
+func (vd *Vedirect) VeCommandSet(address uint16, value []byte) (err error) {
+	debugPrintf("vedirect: VeCommandSet begin address=%x value=%x", address, value)
+
+	// setting the same value again is harmless; retry like VeCommandGet to deal with old data in the buffers
+	const numbTries = 4
+	for try := 0; try < numbTries; try++ {
+		var rawValues []byte
+		rawValues, err = vd.veCommand(VeCommandSet, address, value)
+		if err != nil {
+			log.Printf("vedirect: VeCommandSet retry try=%v err=%v", try, err)
+			continue
+		}
+
+		if len(rawValues) < 3 {
+			err = errors.New(fmt.Sprintf("response too short, len(rawValues)=%v", len(rawValues)))
+			log.Printf("vedirect: VeCommandSet retry try=%v err=%v", try, err)
+			continue
+		}
+
+		// check address
+		responseAddress := uint16(littleEndianBytesToUint(rawValues[0:2]))
+		if address != responseAddress {
+			err = errors.New(fmt.Sprintf("address != responseAddress, address=%x, responseAddress=%x", address, responseAddress))
+			log.Printf("vedirect: VeCommandSet retry try=%v err=%v", try, err)
+			continue
+		}
+
+		// check flag; the device rejected the value, retrying does not help
+		responseFlag := VeResponseFlag(littleEndianBytesToUint(rawValues[2:3]))
+		if VeResponseFlagOk != responseFlag {
+			err = errors.New(fmt.Sprintf("VeResponseFlagOk != responseFlag, responseFlag=%v", responseFlag))
+			debugPrintf("vedirect: VeCommandSet end err=%v", err)
+			return err
+		}
+
+		debugPrintf("vedirect: VeCommandSet end")
+		return nil
+	}
+
+	debugPrintf("vedirect: VeCommandSet end tries=%v last err=%v", numbTries, err)
+	return errors.New(fmt.Sprintf("gave up after %v tries, last err=%v", numbTries, err))
+}
+
+// VeCommandRestart restarts the device; there is no response
+func (vd *Vedirect) VeCommandRestart() (err error) {
+	debugPrintf("vedirect: VeCommandRestart begin")
+	err = vd.SendVeCommand(VeCommandRestart, []byte{})
+	debugPrintf("vedirect: VeCommandRestart end err=%v", err)
+	return
+}
+
 func (vd *Vedirect) RecvVeResponse() (data []byte, err error) {
 	debugPrintf("vedirect: RecvVeResponse begin")
 
@@ -9,6 +9,7 @@ import (
 )
 
 type Vedirect struct {
+	portName string
 	ioHandle io.ReadWriteCloser
 }
 
@@ -31,7 +32,7 @@ func Open(portName string) (*Vedirect, error) {
 
 	log.Printf("vedirect: Open succeeded portName=%v, ioHandle=%v", portName, ioHandle)
 
-	return &Vedirect{ioHandle: ioHandle}, nil
+	return &Vedirect{portName: portName, ioHandle: ioHandle}, nil
 }
 
 func (vd *Vedirect) Close() (err error) {